@@ -23,7 +23,10 @@ func main() {
 	defer migrCloser()
 	defer pool.Close()
 
-	srv := server.New(cfg, pool)
+	srv, err := server.New(cfg, pool)
+	if err != nil {
+		log.Fatalf("server: %v", err)
+	}
 	httpSrv := srv.HTTPServer()
 
 	go func() {
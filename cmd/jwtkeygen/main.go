@@ -1,18 +1,47 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("kullanım: jwtkeygen <kid>")
+	alg := flag.String("alg", jwtauth.HS256, "HS256|RS256|ES256|EdDSA")
+	out := flag.String("out", ".", "asimetrik anahtarlar için PEM dizini")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("kullanım: jwtkeygen [-alg HS256|RS256|ES256|EdDSA] [-out dizin] <kid>")
+		return
+	}
+	kid := flag.Arg(0)
+
+	k, err := jwtauth.Generate(kid, *alg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if k.Alg == jwtauth.HS256 {
+		fmt.Printf("%s:%s\n", kid, k.Secret)
 		return
 	}
-	var b [32]byte
-	_, _ = rand.Read(b[:])
-	fmt.Printf("%s:%s\n", os.Args[1], hex.EncodeToString(b[:]))
+
+	pemBytes, err := jwtauth.MarshalPrivateKeyPEM(k)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	path := filepath.Join(*out, kid+".pem")
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	jwk, _ := jwtauth.PublicJWK(k)
+	frag, _ := json.MarshalIndent(jwk, "", "  ")
+	fmt.Printf("JWT_KEY_FILES: %s:%s\n", kid, path)
+	fmt.Printf("JWKS:\n%s\n", frag)
 }
@@ -19,6 +19,13 @@ type Config struct {
 	CorsOrigins               []string
 	MetricsAllowCIDR          string
 	BcryptCost                int
+	PasswordHash              string
+	Argon2Memory              uint32
+	Argon2Time                uint32
+	Argon2Threads             uint8
+	PasswordMinLength         int
+	PasswordMinScore          int
+	PasswordBreachDir         string
 	RateRPS                   float64
 	RateBurst                 int
 	JWTIssuer                 string
@@ -32,7 +39,42 @@ type Config struct {
 	RedisDB                   int
 	RedisTLS                  bool
 	JTIPrefix                 string
+	JTIFailOpen               bool
+	JTICacheTTL               time.Duration
+	AccountFailOpen           bool
+	AccountCacheTTL           time.Duration
 	RateAllowCIDR             string
+	NotesCacheTTL             time.Duration
+	NotesRequireBase          bool
+	RoleCacheTTL              time.Duration
+	JWTKeyDir                 string
+	JWTKeyAlg                 string
+	JWTKeyPoll                time.Duration
+	RefreshReuseGrace         time.Duration
+	RefreshPepper             []byte
+	AppBaseURL                string
+	MailDriver                string
+	MailFrom                  string
+	MailOutboxDir             string
+	SMTPAddr                  string
+	SMTPUser                  string
+	SMTPPass                  string
+	MailTimeout               time.Duration
+	VerifyPolicy              string
+	VerifyTTL                 time.Duration
+	PasswordResetTTL          time.Duration
+	InviteTTL                 time.Duration
+	AdminRequireMFA           bool
+	ImpersonationTTL          time.Duration
+	OIDCIssuer                string
+	OIDCClientID              string
+	OIDCClientSecret          string
+	OIDCRedirectURL           string
+	OIDCScopes                []string
+	OIDCGroupsClaim           string
+	OIDCRoleMap               map[string]string
+	OIDCProvision             bool
+	OIDCTrustAMR              bool
 }
 
 func getenv(k, def string) string {
@@ -49,6 +91,13 @@ func mustDur(k, def string) time.Duration {
 	}
 	return d
 }
+func mustDurIn(k, def string, lo, hi time.Duration) time.Duration {
+	d := mustDur(k, def)
+	if d < lo || d > hi {
+		panic(k + ": must be between " + lo.String() + " and " + hi.String())
+	}
+	return d
+}
 func mustInt(k, def string) int {
 	v := getenv(k, def)
 	n, err := strconv.Atoi(v)
@@ -57,6 +106,13 @@ func mustInt(k, def string) int {
 	}
 	return n
 }
+func mustIntIn(k, def string, lo, hi int) int {
+	n := mustInt(k, def)
+	if n < lo || n > hi {
+		panic(k + ": must be between " + strconv.Itoa(lo) + " and " + strconv.Itoa(hi))
+	}
+	return n
+}
 func mustFloat(k, def string) float64 {
 	v := getenv(k, def)
 	f, err := strconv.ParseFloat(v, 64)
@@ -65,6 +121,15 @@ func mustFloat(k, def string) float64 {
 	}
 	return f
 }
+func mustOneOf(k, def string, allowed ...string) string {
+	v := getenv(k, def)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	panic(k + ": must be one of " + strings.Join(allowed, "|"))
+}
 func splitCSV(s string) []string {
 	if s == "" {
 		return nil
@@ -76,6 +141,19 @@ func splitCSV(s string) []string {
 	return a
 }
 
+// mustPairs parses "a:b,c:d" into a map.
+func mustPairs(k, def string) map[string]string {
+	m := map[string]string{}
+	for _, p := range splitCSV(getenv(k, def)) {
+		a, b, ok := strings.Cut(p, ":")
+		if !ok || a == "" || b == "" {
+			panic(k + ": invalid pair " + p)
+		}
+		m[a] = b
+	}
+	return m
+}
+
 func mysqlDSNFromEnv() string {
 	if dsn := os.Getenv("DB_DSN"); dsn != "" {
 		return dsn
@@ -107,6 +185,13 @@ func Load() Config {
 		JWTTTL:     mustDur("JWT_TTL", "15m"),
 		RefreshTTL: mustDur("REFRESH_TTL", "720h"),
 
+		RefreshReuseGrace: mustDur("REFRESH_REUSE_GRACE", "10s"),
+		RefreshPepper:     []byte(getenv("REFRESH_PEPPER", "")),
+
+		JWTKeyDir:  getenv("JWT_KEY_DIR", ""),
+		JWTKeyAlg:  getenv("JWT_KEY_ALG", "HS256"),
+		JWTKeyPoll: mustDur("JWT_KEY_POLL", "10s"),
+
 		OTELEndpoint: getenv("OTEL_ENDPOINT", ""),
 		OTELSample:   mustFloat("OTEL_SAMPLER", "0.1"),
 
@@ -116,13 +201,56 @@ func Load() Config {
 		RedisTLS:  getenv("REDIS_TLS", "false") == "true",
 		JTIPrefix: getenv("JWT_JTI_PREFIX", "jti:"),
 
-		RateAllowCIDR: getenv("RATE_ALLOW_CIDR", ""),
+		JTIFailOpen: getenv("JWT_JTI_FAIL_OPEN", "true") == "true",
+		JTICacheTTL: mustDur("JWT_JTI_CACHE_TTL", "5s"),
+
+		AccountFailOpen: getenv("ACCOUNT_FAIL_OPEN", "false") == "true",
+		AccountCacheTTL: mustDurIn("ACCOUNT_CACHE_TTL", "5s", 0, time.Minute),
+
+		RateAllowCIDR:    getenv("RATE_ALLOW_CIDR", ""),
+		NotesCacheTTL:    mustDur("NOTES_CACHE_TTL", "0s"),
+		NotesRequireBase: getenv("NOTES_REQUIRE_BASE", "false") == "true",
+		RoleCacheTTL:     mustDur("ROLE_CACHE_TTL", "30s"),
+
+		AppBaseURL:    getenv("APP_BASE_URL", "http://localhost:8080"),
+		MailDriver:    getenv("MAIL_DRIVER", "outbox"),
+		MailFrom:      getenv("MAIL_FROM", "no-reply@localhost"),
+		MailOutboxDir: getenv("MAIL_OUTBOX_DIR", ""),
+		SMTPAddr:      getenv("SMTP_ADDR", "127.0.0.1:25"),
+		SMTPUser:      getenv("SMTP_USER", ""),
+		SMTPPass:      getenv("SMTP_PASS", ""),
+		MailTimeout:   mustDurIn("MAIL_TIMEOUT", "10s", time.Second, 2*time.Minute),
+		VerifyPolicy:  mustOneOf("VERIFY_POLICY", "none", "none", "login", "notes"),
+		VerifyTTL:     mustDur("VERIFY_TTL", "48h"),
+
+		PasswordResetTTL: mustDur("PASSWORD_RESET_TTL", "1h"),
+		InviteTTL:        mustDur("INVITE_TTL", "72h"),
+		AdminRequireMFA:  getenv("ADMIN_REQUIRE_MFA", "true") == "true",
+		ImpersonationTTL: mustDurIn("IMPERSONATION_TTL", "15m", time.Minute, time.Hour),
+
+		OIDCIssuer:       getenv("OIDC_ISSUER", ""),
+		OIDCClientID:     getenv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getenv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getenv("OIDC_REDIRECT_URL", strings.TrimSuffix(getenv("APP_BASE_URL", "http://localhost:8080"), "/")+"/auth/oidc/callback"),
+		OIDCScopes:       splitCSV(getenv("OIDC_SCOPES", "openid,email,profile")),
+		OIDCGroupsClaim:  getenv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMap:      mustPairs("OIDC_ROLE_MAP", ""),
+		OIDCProvision:    getenv("OIDC_PROVISION", "true") == "true",
+		OIDCTrustAMR:     getenv("OIDC_TRUST_AMR", "false") == "true",
 
 		MaxBodyBytes:     int64(mustInt("MAX_BODY_BYTES", "1048576")),
 		CorsOrigins:      splitCSV(getenv("CORS_ORIGINS", "*")),
 		MetricsAllowCIDR: getenv("METRICS_ALLOW", "127.0.0.1/32"),
 		BcryptCost:       mustInt("BCRYPT_COST", "12"),
+		PasswordHash:     mustOneOf("PASSWORD_HASH", "argon2id", "argon2id", "bcrypt"),
+		Argon2Memory:     uint32(mustIntIn("ARGON2_MEMORY_KIB", "65536", 8*1024, 256*1024)),
+		Argon2Time:       uint32(mustIntIn("ARGON2_TIME", "3", 1, 16)),
+		Argon2Threads:    uint8(mustIntIn("ARGON2_THREADS", "2", 1, 16)),
 		RateRPS:          mustFloat("RATE_RPS", "10"),
 		RateBurst:        mustInt("RATE_BURST", "10"),
+
+		PasswordMinLength: mustInt("PASSWORD_MIN_LENGTH", "8"),
+		PasswordMinScore:  mustInt("PASSWORD_MIN_SCORE", "2"),
+		PasswordBreachDir: getenv("PASSWORD_BREACH_DIR", ""),
 	}
 }
@@ -24,7 +24,7 @@ func OpenAndMigrate(cfg config.Config) (*sql.DB, func(), error) {
 		return nil, nil, err
 	}
 
-	src, err := file.New("file://migrations")
+	src, err := (&file.File{}).Open("file://migrations")
 	if err != nil {
 		sqlDB.Close()
 		return nil, nil, err
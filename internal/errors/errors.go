@@ -14,6 +14,7 @@ type AppError struct {
 	Code    string            `json:"code"`
 	Message string            `json:"message"`
 	Fields  map[string]string `json:"fields,omitempty"`
+	Details any               `json:"details,omitempty"`
 	Err     error             `json:"-"`
 }
 
@@ -48,9 +49,13 @@ func Write(w http.ResponseWriter, r *http.Request, err error) {
 	rid := r.Header.Get("X-Request-ID")
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(app.Status)
-	_ = json.NewEncoder(w).Encode(map[string]any{
+	body := map[string]any{
 		"code": app.Code, "message": app.Message, "rid": rid, "fields": app.Fields,
-	})
+	}
+	if app.Details != nil {
+		body["details"] = app.Details
+	}
+	_ = json.NewEncoder(w).Encode(body)
 
 	log := logging.New()
 	lvl := slog.LevelError
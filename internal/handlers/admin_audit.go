@@ -50,15 +50,18 @@ func (h AdminAudit) List(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
 		cw := csv.NewWriter(w)
-		_ = cw.Write([]string{"id", "user_id", "method", "path", "status", "ip", "rid", "created_at"})
+		_ = cw.Write([]string{"id", "user_id", "actor_id", "method", "path", "status", "ip", "rid", "action", "meta", "created_at"})
 		for _, a := range rows {
-			uid := ""
+			uid, actor := "", ""
 			if a.UserID.Valid {
 				uid = strconv.FormatInt(a.UserID.Int64, 10)
 			}
+			if a.ActorID.Valid {
+				actor = strconv.FormatInt(a.ActorID.Int64, 10)
+			}
 			rec := []string{
-				strconv.FormatInt(a.ID, 10), uid, a.Method, a.Path,
-				strconv.Itoa(a.Status), a.IP, a.RID, a.CreatedAt.Format(time.RFC3339),
+				strconv.FormatInt(a.ID, 10), uid, actor, a.Method, a.Path,
+				strconv.Itoa(a.Status), a.IP, a.RID, a.Action, a.Meta, a.CreatedAt.Format(time.RFC3339),
 			}
 			_ = cw.Write(rec)
 		}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+)
+
+type AdminKeys struct{}
+
+func (AdminKeys) Rotate(w http.ResponseWriter, r *http.Request) {
+	kid, err := jwtauth.Rotate()
+	if errors.Is(err, jwtauth.ErrRotateUnsupported) {
+		apperr.Write(w, r, apperr.E(409, "rotation_unsupported", "keys are not file backed", err, nil))
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "rotate_failed", "rotate failed", err, nil))
+		return
+	}
+	kids := []string{}
+	for _, k := range jwtauth.Provider().Keys() {
+		kids = append(kids, k.KID)
+	}
+	cur := jwtauth.Provider().CurrentKID()
+	out := map[string]any{"current": cur, "kids": kids}
+	if cur != kid {
+		// Published now, signing once verifiers have refreshed the JWKS.
+		out["next"] = kid
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -2,11 +2,18 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
 	"github.com/Veysel440/go-notes-api/internal/repos"
 	"github.com/go-chi/chi/v5"
 )
@@ -14,6 +21,52 @@ import (
 type AdminRoles struct {
 	Cfg   config.Config
 	Roles *repos.Roles
+	Audit *repos.Audit
+	// Perms limits what the acting admin may hand out to the permissions
+	// they hold themselves.
+	Perms middleware.PermissionSource
+}
+
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// checkGrant refuses to grant perms unless the acting user already holds
+// every one of them, so roles:write alone cannot be used to climb to admin.
+func checkGrant(ctx context.Context, r *http.Request, src middleware.PermissionSource, perms []string) error {
+	if src == nil || len(perms) == 0 {
+		return nil
+	}
+	actor, _ := middleware.UserID(r.Context())
+	held, err := src.Permissions(ctx, actor)
+	if err != nil {
+		return apperr.E(503, "authz_unavailable", "authorization unavailable", err, nil)
+	}
+	var missing []string
+	for _, p := range perms {
+		if !slices.Contains(held, p) && !slices.Contains(missing, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		e := apperr.E(403, "forbidden", "cannot grant permissions you do not hold", nil, nil)
+		e.Details = map[string]any{"missing": missing}
+		return e
+	}
+	return nil
+}
+
+// rolePermissions returns the permissions of the named role, sql.ErrNoRows
+// if it does not exist.
+func rolePermissions(ctx context.Context, roles *repos.Roles, name string) ([]string, error) {
+	all, err := roles.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ro := range all {
+		if ro.Name == name {
+			return ro.Permissions, nil
+		}
+	}
+	return nil, sql.ErrNoRows
 }
 
 func (h AdminRoles) Post(w http.ResponseWriter, r *http.Request) {
@@ -34,20 +87,190 @@ func (h AdminRoles) Post(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
 	defer cancel()
 
-	switch in.Action {
-	case "add":
-		if err := h.Roles.Assign(ctx, id, in.Role); err != nil {
-			http.Error(w, "server", 500)
+	if in.Action != "add" && in.Action != "remove" {
+		http.Error(w, "invalid action", 422)
+		return
+	}
+	// Taking a role away needs the same permissions as handing it out, so
+	// roles:write alone cannot strip an administrator.
+	var perms []string
+	if perms, err = rolePermissions(ctx, h.Roles, in.Role); err == nil {
+		if err := checkGrant(ctx, r, h.Perms, perms); err != nil {
+			apperr.Write(w, r, err)
 			return
 		}
-	case "remove":
-		if err := h.Roles.Unassign(ctx, id, in.Role); err != nil {
-			http.Error(w, "server", 500)
-			return
+		if in.Action == "add" {
+			err = h.Roles.Assign(ctx, id, in.Role)
+		} else {
+			err = h.Roles.Unassign(ctx, id, in.Role)
 		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.Validation(map[string]string{"role": "unknown role"}))
+		return
+	}
+	if err != nil {
+		http.Error(w, "server", 500)
+		return
+	}
+	recordAdminEvent(h.Audit, r, id, "role_"+in.Action, http.StatusNoContent, map[string]any{"role": in.Role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h AdminRoles) List(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	roles, err := h.Roles.List(ctx)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	perms, err := h.Roles.AllPermissions(ctx)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": roles, "permissions": perms})
+}
+
+func (h AdminRoles) UserRoles(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	roles, err := h.Roles.UserRoles(ctx, id)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	perms, err := h.Roles.Permissions(ctx, id)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"roles": roles, "permissions": perms})
+}
+
+func decodeRole(r *http.Request, name string) (repos.Role, error) {
+	var in struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return repos.Role{}, apperr.BadRequest
+	}
+	if name != "" {
+		in.Name = name
+	}
+	fields := map[string]string{}
+	if !roleName.MatchString(in.Name) {
+		fields["name"] = "2-50 characters: lowercase letters, digits, - and _"
+	}
+	in.Description = strings.TrimSpace(in.Description)
+	if len(in.Description) > 255 {
+		fields["description"] = "max 255 characters"
+	}
+	if len(fields) > 0 {
+		return repos.Role{}, apperr.Validation(fields)
+	}
+	slices.Sort(in.Permissions)
+	return repos.Role{Name: in.Name, Description: in.Description, Permissions: slices.Compact(in.Permissions)}, nil
+}
+
+func (h AdminRoles) writeRoleErr(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		apperr.Write(w, r, apperr.NotFound)
+	case errors.Is(err, repos.ErrUnknownPermission):
+		apperr.Write(w, r, apperr.Validation(map[string]string{"permissions": "unknown permission"}))
+	case errors.Is(err, repos.ErrBuiltinRole):
+		apperr.Write(w, r, apperr.E(409, "builtin_role", "builtin role cannot be changed", nil, nil))
 	default:
-		http.Error(w, "invalid action", 422)
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+	}
+}
+
+func (h AdminRoles) Create(w http.ResponseWriter, r *http.Request) {
+	ro, err := decodeRole(r, "")
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if err := checkGrant(ctx, r, h.Perms, ro.Permissions); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.Roles.Create(ctx, ro); err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			apperr.Write(w, r, apperr.E(409, "role_exists", "role already exists", nil, nil))
+			return
+		}
+		h.writeRoleErr(w, r, err)
+		return
+	}
+	recordAdminEvent(h.Audit, r, 0, "role_created", http.StatusCreated, map[string]any{"role": ro.Name, "permissions": ro.Permissions})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ro)
+}
+
+func (h AdminRoles) Update(w http.ResponseWriter, r *http.Request) {
+	ro, err := decodeRole(r, chi.URLParam(r, "name"))
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	// The permissions being taken away count as much as the new ones.
+	old, err := rolePermissions(ctx, h.Roles, ro.Name)
+	if err != nil {
+		h.writeRoleErr(w, r, err)
+		return
+	}
+	if err := checkGrant(ctx, r, h.Perms, append(old, ro.Permissions...)); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.Roles.Update(ctx, ro); err != nil {
+		h.writeRoleErr(w, r, err)
+		return
+	}
+	recordAdminEvent(h.Audit, r, 0, "role_updated", http.StatusOK, map[string]any{"role": ro.Name, "permissions": ro.Permissions})
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ro)
+}
+
+func (h AdminRoles) Delete(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	perms, err := rolePermissions(ctx, h.Roles, name)
+	if err != nil {
+		h.writeRoleErr(w, r, err)
+		return
+	}
+	if err := checkGrant(ctx, r, h.Perms, perms); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.Roles.Delete(ctx, name); err != nil {
+		h.writeRoleErr(w, r, err)
 		return
 	}
+	recordAdminEvent(h.Audit, r, 0, "role_deleted", http.StatusNoContent, map[string]any{"role": name})
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/go-chi/chi/v5"
+)
+
+type fixedPerms []string
+
+func (f fixedPerms) Permissions(context.Context, int64) ([]string, error) { return f, nil }
+
+func TestAdminRoles_CannotGrantMoreThanHeld(t *testing.T) {
+	withTestKeys(t)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", DBTimeout: time.Second}
+	access, _ := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+
+	h := AdminRoles{Cfg: cfg, Roles: &repos.Roles{DB: db}, Perms: fixedPerms{"roles:read", "roles:write"}}
+	r := chi.NewRouter()
+	r.Use(middleware.AuthWith(cfg, middleware.AuthDeps{}))
+	r.Post("/admin/users/{id}/roles", h.Post)
+	r.Post("/admin/roles", h.Create)
+	r.Put("/admin/roles/{name}", h.Update)
+	r.Delete("/admin/roles/{name}", h.Delete)
+
+	roles := func() {
+		mock.ExpectQuery(regexp.QuoteMeta("FROM roles ro")).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "description", "builtin", "perms"}).
+				AddRow("admin", "", true, "keys:rotate roles:read roles:write users:write").
+				AddRow("ops", "", false, "keys:rotate roles:read").
+				AddRow("user", "", true, "notes:read notes:write"))
+	}
+
+	for _, c := range []struct {
+		method, path, body string
+		lists              bool
+	}{
+		{"POST", "/admin/users/7/roles", `{"action":"add","role":"admin"}`, true},
+		{"POST", "/admin/users/9/roles", `{"action":"remove","role":"admin"}`, true},
+		{"POST", "/admin/roles", `{"name":"ops","permissions":["roles:write","keys:rotate"]}`, false},
+		{"PUT", "/admin/roles/ops", `{"permissions":["users:write"]}`, true},
+		{"PUT", "/admin/roles/ops", `{"permissions":["roles:read"]}`, true},
+		{"DELETE", "/admin/roles/ops", ``, true},
+	} {
+		if c.lists {
+			roles()
+		}
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer "+access)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != 403 || !strings.Contains(rec.Body.String(), "missing") {
+			t.Fatalf("%s %s: %d %s", c.method, c.path, rec.Code, rec.Body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
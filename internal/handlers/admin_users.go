@@ -2,17 +2,51 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/mail"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
 	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type AdminUsers struct {
-	Cfg   config.Config
-	Users *repos.Users
+	Cfg      config.Config
+	Users    *repos.Users
+	Roles    *repos.Roles
+	Tokens   *repos.RefreshTokens
+	Resets   *repos.PasswordResets
+	Versions *repos.TokenVersions
+	PATs     *repos.PATs
+	Notes    *repos.Notes
+	OAuth    *repos.OAuth
+	JTIStore jtiRevoker
+	Mailer   mail.Mailer
+	Audit    *repos.Audit
+	// Perms is consulted when a new user is given roles other than "user",
+	// which needs roles:write as well as every permission those roles carry.
+	Perms middleware.PermissionSource
+}
+
+func userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return 0, false
+	}
+	return id, true
 }
 
 func (h AdminUsers) List(w http.ResponseWriter, r *http.Request) {
@@ -34,3 +68,403 @@ func (h AdminUsers) List(w http.ResponseWriter, r *http.Request) {
 		"page": page, "size": size, "total": total,
 	})
 }
+
+// LogoutAll invalidates every access and refresh token of the user.
+func (h AdminUsers) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "logout failed, retry", err, nil))
+		return
+	}
+	recordAdminEvent(h.Audit, r, id, "admin_logout_all", http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetStatus activates, suspends or disables an account. Blocking ends every
+// session of the user immediately.
+func (h AdminUsers) SetStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Status string     `json:"status"`
+		Until  *time.Time `json:"until"`
+		Reason string     `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	fields := map[string]string{}
+	switch in.Status {
+	case repos.StatusActive:
+		in.Until, in.Reason = nil, ""
+	case repos.StatusSuspended:
+		if in.Until == nil || !in.Until.After(time.Now()) {
+			fields["until"] = "required, must be in the future"
+		}
+	case repos.StatusDisabled:
+		in.Until = nil
+	default:
+		fields["status"] = "must be active, suspended or disabled"
+	}
+	if in.Status != repos.StatusActive && in.Reason == "" {
+		fields["reason"] = "required"
+	}
+	if len(in.Reason) > 255 {
+		fields["reason"] = "max 255 characters"
+	}
+	actor, _ := middleware.UserID(r.Context())
+	if id == actor && in.Status != repos.StatusActive {
+		fields["status"] = "cannot block your own account"
+	}
+	if len(fields) > 0 {
+		apperr.Write(w, r, apperr.Validation(fields))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	err := h.Users.SetStatus(ctx, id, in.Status, in.Until, in.Reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	// The bump also refreshes the cached state that authentication reads.
+	if err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, id); err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "status saved but sessions were not ended, retry", err, nil))
+		return
+	}
+	recordAdminEvent(h.Audit, r, id, "user_status", http.StatusOK, map[string]any{
+		"status": in.Status, "until": in.Until, "reason": in.Reason,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": in.Status, "until": in.Until, "reason": in.Reason})
+}
+
+// Get returns everything support needs about one account.
+func (h AdminUsers) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	d, err := h.Users.Detail(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	roles, err := h.Roles.UserRoles(ctx, id)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	sessions, err := h.Tokens.Sessions(ctx, id, "")
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"user": d, "roles": roles, "sessions": sessions})
+}
+
+// sendReset mails uid a single-use link to choose a password.
+func (h AdminUsers) sendReset(r *http.Request, uid int64, email string, ttl time.Duration, subject, intro string) (time.Time, error) {
+	raw, err := h.Resets.Create(r.Context(), uid, ttl)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if h.Mailer != nil {
+		err = h.Mailer.Send(context.WithoutCancel(r.Context()), mail.Message{
+			To:      email,
+			Subject: subject,
+			Body: fmt.Sprintf("%s\n\n%s\n\nToken: %s\n\nThe link expires in %s.",
+				intro, resetLink(h.Cfg, raw), raw, ttl),
+		})
+	}
+	return time.Now().Add(ttl), err
+}
+
+// Create adds an account without a usable password and mails the user an
+// invite link to set one.
+func (h AdminUsers) Create(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string   `json:"email"`
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	if in.Roles == nil {
+		in.Roles = []string{"user"}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	fields := map[string]string{}
+	if validate.Var(in.Email, "required,email,max=200") != nil {
+		fields["email"] = "must be a valid address"
+	}
+	known, err := h.Roles.List(ctx)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	var grants []string
+	for _, role := range in.Roles {
+		i := slices.IndexFunc(known, func(k repos.Role) bool { return k.Name == role })
+		if i < 0 {
+			fields["roles"] = "unknown role " + role
+			continue
+		}
+		grants = append(grants, known[i].Permissions...)
+	}
+	if len(fields) > 0 {
+		apperr.Write(w, r, apperr.Validation(fields))
+		return
+	}
+	if !slices.Equal(in.Roles, []string{"user"}) && h.Perms != nil {
+		actor, _ := middleware.UserID(r.Context())
+		perms, err := h.Perms.Permissions(ctx, actor)
+		if err != nil {
+			apperr.Write(w, r, apperr.E(503, "authz_unavailable", "authorization unavailable", err, nil))
+			return
+		}
+		if !slices.Contains(perms, "roles:write") {
+			e := apperr.E(403, "forbidden", "missing permission", nil, nil)
+			e.Details = map[string]any{"required": []string{"roles:write"}}
+			apperr.Write(w, r, e)
+			return
+		}
+		if err := checkGrant(ctx, r, h.Perms, grants); err != nil {
+			apperr.Write(w, r, err)
+			return
+		}
+	}
+
+	// An empty hash never verifies: the account is unusable until the invite
+	// is accepted.
+	id, err := h.Users.CreateWithRoles(ctx, in.Email, "", in.Roles)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			apperr.Write(w, r, apperr.E(409, "email_taken", "email already registered", nil, nil))
+			return
+		}
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	exp, err := h.sendReset(r, id, in.Email, h.Cfg.InviteTTL, "You have been invited",
+		"An account has been created for you. Open the link below or send the token to POST /auth/password/reset to choose your password.")
+	recordAdminEvent(h.Audit, r, id, "user_created", http.StatusCreated, map[string]any{"roles": in.Roles, "invite_sent": err == nil})
+	if err != nil {
+		e := apperr.E(500, "invite_failed", "user created but the invite was not sent; use the password reset endpoint", err, nil)
+		e.Details = map[string]any{"id": id}
+		apperr.Write(w, r, e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "email": in.Email, "roles": in.Roles, "invite_expires_at": exp})
+}
+
+// ForceReset invalidates the current password, ends every session and mails
+// a reset link.
+func (h AdminUsers) ForceReset(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	u, err := h.Users.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, id, ""); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, id); err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "password cleared but sessions were not ended, retry", err, nil))
+		return
+	}
+	_, err = h.sendReset(r, id, u.Email, h.Cfg.PasswordResetTTL, "Reset your password",
+		"An administrator has reset your password. Open the link below or send the token to POST /auth/password/reset to choose a new one.")
+	recordAdminEvent(h.Audit, r, id, "admin_password_reset", http.StatusNoContent, map[string]any{"mail_sent": err == nil})
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "mail_failed", "password cleared but the reset link was not sent, retry", err, nil))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete removes the account and all of its data. Live access tokens stop
+// working because the user's state is reported as deleted.
+func (h AdminUsers) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	if actor, _ := middleware.UserID(r.Context()); actor == id {
+		apperr.Write(w, r, apperr.E(409, "self_delete", "cannot delete your own account", nil, nil))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	u, err := h.Users.FindByID(ctx, id)
+	if err == nil {
+		err = h.revokeOwnedClients(ctx, id)
+	}
+	if err == nil {
+		err = h.Users.Delete(ctx, id)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if h.Versions != nil {
+		_ = h.Versions.Forget(ctx, id)
+	}
+	if h.Notes != nil {
+		h.Notes.Forget(ctx, id)
+	}
+	if h.Roles != nil {
+		h.Roles.Forget(ctx, id)
+	}
+	recordAdminEvent(h.Audit, r, id, "user_deleted", http.StatusNoContent, map[string]any{"email": u.Email})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOwnedClients ends the tokens other users hold for OAuth clients uid
+// owns; the clients themselves go with the user.
+func (h AdminUsers) revokeOwnedClients(ctx context.Context, uid int64) error {
+	if h.OAuth == nil || h.Tokens == nil {
+		return nil
+	}
+	cs, err := h.OAuth.Clients(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		ss, err := h.Tokens.RevokeClientAll(ctx, c.ClientID)
+		for _, s := range ss {
+			revokeAccess(ctx, h.JTIStore, s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Impersonate issues a short-lived access token for the user that also names
+// the admin in an "act" claim. There is no refresh token; requests made with
+// it are audited with the admin as actor and credential endpoints refuse it.
+func (h AdminUsers) Impersonate(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.Reason == "" || len(in.Reason) > 255 {
+		apperr.Write(w, r, apperr.Validation(map[string]string{"reason": "required, max 255 characters"}))
+		return
+	}
+	actor, _ := middleware.UserID(r.Context())
+	if id == actor {
+		apperr.Write(w, r, apperr.E(409, "self_impersonation", "cannot impersonate yourself", nil, nil))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	// The admin's own token version goes into "act" so their logout-all
+	// ends the impersonation as well.
+	var st, actSt repos.AccountState
+	if h.Versions != nil {
+		var err error
+		if st, err = h.Versions.State(ctx, id); err == nil {
+			actSt, err = h.Versions.State(ctx, actor)
+		}
+		if err != nil {
+			apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+			return
+		}
+	}
+	switch {
+	case st.Status == repos.StatusDeleted:
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	case st.Blocked(time.Now()):
+		apperr.Write(w, r, apperr.E(409, "account_"+st.Status, "account "+st.Status, nil, nil))
+		return
+	}
+
+	now := time.Now()
+	exp := now.Add(h.Cfg.ImpersonationTTL)
+	jti := randID()
+	access, err := jwtauth.Sign(jwt.MapClaims{
+		"sub": id,
+		"act": map[string]any{"sub": actor, "ver": actSt.Version},
+		"amr": []string{"imp"},
+		"ver": st.Version,
+		"iss": h.Cfg.JWTIssuer,
+		"aud": h.Cfg.JWTAudience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": exp.Unix(),
+	})
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "server error", err, nil))
+		return
+	}
+	recordAdminEvent(h.Audit, r, id, "impersonation_started", http.StatusOK, map[string]any{
+		"reason": in.Reason, "jti": jti, "expires_at": exp,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{"access": access, "jti": jti, "expires_at": exp})
+}
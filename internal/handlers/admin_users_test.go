@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAdminUsers_SetStatusValidation(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	access, err := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	r.With(middleware.AuthWith(cfg, middleware.AuthDeps{})).Put("/admin/users/{id}/status", AdminUsers{Cfg: cfg}.SetStatus)
+
+	for _, c := range []struct {
+		id, body, field string
+	}{
+		{"9", `{"status":"banned","reason":"x"}`, "status"},
+		{"9", `{"status":"suspended","reason":"spam"}`, "until"},
+		{"9", `{"status":"suspended","until":"2001-01-01T00:00:00Z","reason":"spam"}`, "until"},
+		{"9", `{"status":"disabled"}`, "reason"},
+		{"7", `{"status":"disabled","reason":"self"}`, "status"},
+	} {
+		req := httptest.NewRequest("PUT", "/admin/users/"+c.id+"/status", strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer "+access)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != 422 || !strings.Contains(rec.Body.String(), `"`+c.field+`"`) {
+			t.Fatalf("%s: %d %s", c.body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAdminUsers_ImpersonateIssuesActClaim(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", ImpersonationTTL: time.Minute}
+	access, _ := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+	r := chi.NewRouter()
+	r.With(middleware.AuthWith(cfg, middleware.AuthDeps{})).Post("/admin/users/{id}/impersonate", AdminUsers{Cfg: cfg}.Impersonate)
+
+	do := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/users/9/impersonate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+access)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do(`{"reason":"  "}`); rec.Code != 422 {
+		t.Fatalf("blank reason: %d", rec.Code)
+	}
+	rec := do(`{"reason":"ticket 4711"}`)
+	if rec.Code != 200 {
+		t.Fatalf("impersonate: %d %s", rec.Code, rec.Body)
+	}
+	var out struct{ Access string }
+	_ = json.NewDecoder(rec.Body).Decode(&out)
+
+	var seen, actor int64
+	h := middleware.AuthWith(cfg, middleware.AuthDeps{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = middleware.UserID(r.Context())
+		actor, _ = middleware.Actor(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/notes", nil)
+	req.Header.Set("Authorization", "Bearer "+out.Access)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != 9 || actor != 7 {
+		t.Fatalf("sub %d act %d", seen, actor)
+	}
+}
+
+type recordedRevokes []string
+
+func (r *recordedRevokes) Revoke(_ context.Context, jti string, _ time.Duration) error {
+	*r = append(*r, jti)
+	return nil
+}
+
+func TestAdminUsers_DeleteRevokesTokensOfOwnedClients(t *testing.T) {
+	withTestKeys(t)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", DBTimeout: time.Second}
+	access, _ := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("from users where id=?")).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}).AddRow(int64(9), "b@example.com", "", true))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM oauth_clients WHERE owner_id=?")).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "name", "redirect_uris", "scopes", "owner_id", "created_at"}).
+			AddRow(int64(1), "gnc_a", "", "app", "https://app/cb", "notes:read", int64(9), now))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens")).WithArgs("gnc_a").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_name", "user_agent", "ip", "created_at", "last_used_at", "expires_at", "access_jti", "access_exp"}).
+			AddRow(int64(5), "", "", "", now, nil, now.Add(time.Hour), "j-other", now.Add(time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE client_id=?")).WithArgs("gnc_a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select 1 from users where id=? for update")).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	for range 8 {
+		mock.ExpectExec("delete").WithArgs(int64(9)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	var revoked recordedRevokes
+	h := AdminUsers{Cfg: cfg, Users: &repos.Users{DB: db}, OAuth: &repos.OAuth{DB: db}, Tokens: &repos.RefreshTokens{DB: db}, JTIStore: &revoked}
+	r := chi.NewRouter()
+	r.With(middleware.AuthWith(cfg, middleware.AuthDeps{})).Delete("/admin/users/{id}", h.Delete)
+
+	req := httptest.NewRequest("DELETE", "/admin/users/9", nil)
+	req.Header.Set("Authorization", "Bearer "+access)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || len(revoked) != 1 || revoked[0] != "j-other" {
+		t.Fatalf("delete: %d %s, revoked %v", rec.Code, rec.Body, revoked)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,13 +15,14 @@ import (
 	"github.com/Veysel440/go-notes-api/internal/config"
 	apperr "github.com/Veysel440/go-notes-api/internal/errors"
 	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/password"
 	"github.com/Veysel440/go-notes-api/internal/repos"
 	"github.com/Veysel440/go-notes-api/internal/security"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Auth struct {
@@ -31,43 +32,168 @@ type Auth struct {
 	Roles        *repos.Roles
 	EmailLimiter func(string) bool
 	Metrics      *repos.AuthMetrics
-	JTIStore     interface {
-		Revoke(ctx context.Context, jti string, ttl time.Duration) error
-	}
-	BruteRedis *redis.Client
+	JTIStore     jtiRevoker
+	BruteRedis   *redis.Client
+	Audit        *repos.Audit
+	Verify       *Verify
+	MFA          *repos.MFA
+	Versions     *repos.TokenVersions
 }
 
 type creds struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device_name"`
 }
 
 var validate = validator.New(validator.WithRequiredStructEnabled())
 
 func randID() string { var b [16]byte; _, _ = rand.Read(b[:]); return hex.EncodeToString(b[:]) }
 
+func (h Auth) signAccess(ctx context.Context, uid int64, m repos.SessionMeta) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": uid,
+		"exp": m.AccessExp.Unix(),
+		"iat": time.Now().Unix(),
+		"iss": h.Cfg.JWTIssuer,
+		"aud": h.Cfg.JWTAudience,
+		"jti": m.AccessJTI,
+	}
+	if m.AMR != "" {
+		claims["amr"] = strings.Split(m.AMR, ",")
+	}
+	if m.ClientID != "" {
+		claims["client_id"] = m.ClientID
+		claims["scope"] = m.Scope
+	}
+	if h.Versions != nil {
+		ver, err := h.Versions.Current(ctx, uid)
+		if err != nil {
+			return "", err
+		}
+		claims["ver"] = ver
+	}
+	return jwtauth.Sign(claims)
+}
+
+// blocked returns the error to answer with when uid may not sign in, nil
+// otherwise.
+func (h Auth) blocked(ctx context.Context, uid int64) error {
+	if h.Versions == nil {
+		return nil
+	}
+	st, err := h.Versions.State(ctx, uid)
+	if err != nil {
+		return apperr.E(500, "db_error", "db error", err, nil)
+	}
+	if !st.Blocked(time.Now()) {
+		return nil
+	}
+	e := apperr.E(403, "account_"+st.Status, "account "+st.Status, nil, nil)
+	details := map[string]any{"reason": st.Reason}
+	if st.Status == repos.StatusSuspended {
+		details["until"] = st.SuspendedUntil
+	}
+	e.Details = details
+	return e
+}
+
+// issuePair answers a completed login with a new access/refresh pair.
+func (h Auth) issuePair(ctx context.Context, w http.ResponseWriter, r *http.Request, uid int64, meta repos.SessionMeta) {
+	if err := h.blocked(ctx, uid); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	access, err := h.signAccess(ctx, uid, meta)
+	if err != nil {
+		http.Error(w, "server", http.StatusInternalServerError)
+		return
+	}
+
+	rt, err := h.Tokens.Issue(ctx, uid, time.Now().Add(h.Cfg.RefreshTTL), meta)
+	if err != nil {
+		http.Error(w, "server", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Users.TouchLogin(ctx, uid)
+
+	_ = json.NewEncoder(w).Encode(map[string]any{"access": access, "refresh": rt})
+}
+
+// sessionMeta describes the client behind r and reserves the jti/exp of the
+// access token about to be issued, so the session row can point at it.
+func (h Auth) sessionMeta(r *http.Request, device string) repos.SessionMeta {
+	ua := truncate(r.UserAgent(), 255)
+	if device == "" {
+		device = deviceName(ua)
+	}
+	device = truncate(device, 100)
+	return repos.SessionMeta{
+		UserAgent: ua,
+		IP:        strings.TrimPrefix(middleware.IPKey(r), "ip:"),
+		Device:    device,
+		AccessJTI: randID(),
+		AccessExp: time.Now().Add(h.Cfg.JWTTTL),
+	}
+}
+
+// normEmail is the form of an address used for rate-limit keys, so case and
+// surrounding spaces do not buy extra attempts.
+func normEmail(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
+
+// truncate cuts s to at most n characters, replacing invalid UTF-8 so the
+// result always fits a utf8mb4 column of that width.
+func truncate(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func deviceName(ua string) string {
+	for _, p := range []string{"iPhone", "iPad", "Android", "Windows", "Macintosh", "Linux"} {
+		if strings.Contains(ua, p) {
+			return p
+		}
+	}
+	if i := strings.IndexByte(ua, ' '); i > 0 {
+		return ua[:i]
+	}
+	return ua
+}
+
 func (h Auth) Register(w http.ResponseWriter, r *http.Request) {
 	var in creds
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	var v struct {
-		Email    string `validate:"required,email,max=200"`
-		Password string `validate:"required,min=8,max=128"`
+	fields := map[string]string{}
+	if validate.Var(in.Email, "required,email,max=200") != nil {
+		fields["email"] = "must be a valid address"
 	}
-	v.Email, v.Password = in.Email, in.Password
-	if err := validate.Struct(v); err != nil {
-		http.Error(w, "invalid", http.StatusUnprocessableEntity)
+	if msg := password.PolicyFromConfig(h.Cfg).Check(in.Password, in.Email); msg != "" {
+		fields["password"] = msg
+	}
+	if len(fields) > 0 {
+		apperr.Write(w, r, apperr.Validation(fields))
 		return
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(in.Password), h.Cfg.BcryptCost)
+	hash, err := password.FromConfig(h.Cfg).Hash(in.Password)
+	if err != nil {
+		http.Error(w, "server", http.StatusInternalServerError)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
 	defer cancel()
 
-	id, err := h.Users.Create(ctx, in.Email, string(hash))
+	id, err := h.Users.Create(ctx, in.Email, hash)
 	if err != nil {
 		http.Error(w, "conflict", http.StatusConflict)
 		return
@@ -75,6 +201,9 @@ func (h Auth) Register(w http.ResponseWriter, r *http.Request) {
 	if h.Roles != nil {
 		_ = h.Roles.Assign(ctx, id, "user")
 	}
+	if h.Verify != nil {
+		h.Verify.sendLater(r.Context(), id, in.Email)
+	}
 
 	_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
 }
@@ -85,12 +214,13 @@ func (h Auth) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	if h.EmailLimiter != nil && !h.EmailLimiter(in.Email) {
+	key := normEmail(in.Email)
+	if h.EmailLimiter != nil && !h.EmailLimiter(key) {
 		http.Error(w, "rate limit", http.StatusTooManyRequests)
 		return
 	}
 	br := security.Brute{RDB: h.BruteRedis, Limit: 10, Window: 5 * time.Minute}
-	if ok, _, ttl := br.Allow(r.Context(), r, in.Email); !ok {
+	if ok, _, ttl := br.Allow(r.Context(), r, key); !ok {
 		w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())))
 		apperr.Write(w, r, apperr.TooMany)
 		return
@@ -99,8 +229,13 @@ func (h Auth) Login(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
 	defer cancel()
 
+	hasher := password.FromConfig(h.Cfg)
 	u, err := h.Users.FindByEmail(ctx, in.Email)
-	if err != nil || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(in.Password)) != nil {
+	var ok, rehash bool
+	if err == nil {
+		ok, rehash, _ = hasher.Verify(u.PasswordHash, in.Password)
+	}
+	if !ok {
 		if h.Metrics != nil {
 			h.Metrics.Failed.Inc()
 		}
@@ -108,28 +243,35 @@ func (h Auth) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-
-	claims := jwt.MapClaims{
-		"sub": u.ID,
-		"exp": time.Now().Add(h.Cfg.JWTTTL).Unix(),
-		"iat": time.Now().Unix(),
-		"iss": h.Cfg.JWTIssuer,
-		"aud": h.Cfg.JWTAudience,
-		"jti": randID(),
+	if h.Cfg.VerifyPolicy == "login" && !u.Verified {
+		apperr.Write(w, r, apperr.E(403, "email_not_verified", "email not verified", nil, nil))
+		return
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	kid := jwtauth.CurrentKID()
-	sec, _ := jwtauth.Secret(kid)
-	t.Header["kid"] = kid
-	access, _ := t.SignedString(sec)
-
-	rt, err := h.Tokens.Issue(ctx, u.ID, time.Now().Add(h.Cfg.RefreshTTL))
-	if err != nil {
-		http.Error(w, "server", http.StatusInternalServerError)
+	if err := h.blocked(ctx, u.ID); err != nil {
+		apperr.Write(w, r, err)
 		return
 	}
+	if rehash {
+		if hash, err := hasher.Hash(in.Password); err == nil {
+			_ = h.Users.UpdatePassword(ctx, u.ID, hash)
+		}
+	}
 
-	_ = json.NewEncoder(w).Encode(map[string]any{"access": access, "refresh": rt})
+	if h.MFA != nil {
+		enabled, err := h.MFA.Enabled(ctx, u.ID)
+		if err != nil {
+			http.Error(w, "server", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			h.mfaChallenge(w, u.ID, in.Device, "pwd")
+			return
+		}
+	}
+
+	meta := h.sessionMeta(r, in.Device)
+	meta.AMR = "pwd"
+	h.issuePair(ctx, w, r, u.ID, meta)
 }
 
 func (h Auth) Refresh(w http.ResponseWriter, r *http.Request) {
@@ -144,7 +286,8 @@ func (h Auth) Refresh(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
 	defer cancel()
 
-	uid, newRT, reused, err := h.Tokens.UseAndRotate(ctx, in.Refresh, time.Now().Add(h.Cfg.RefreshTTL))
+	meta := h.sessionMeta(r, "")
+	rot, err := h.Tokens.UseAndRotate(ctx, in.Refresh, time.Now().Add(h.Cfg.RefreshTTL), meta)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "invalid", http.StatusUnauthorized)
@@ -153,26 +296,45 @@ func (h Auth) Refresh(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "server", http.StatusInternalServerError)
 		return
 	}
-	if reused {
+	if rot.Reuse != nil {
+		h.reuseDetected(r, rot, meta)
 		http.Error(w, "token_reused_detected", http.StatusUnauthorized)
 		return
 	}
+	if rot.Grace && h.Metrics != nil {
+		h.Metrics.Reuse.WithLabelValues("grace").Inc()
+	}
+	if err := h.blocked(ctx, rot.UserID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
 
-	claims := jwt.MapClaims{
-		"sub": uid,
-		"exp": time.Now().Add(h.Cfg.JWTTTL).Unix(),
-		"iat": time.Now().Unix(),
-		"iss": h.Cfg.JWTIssuer,
-		"aud": h.Cfg.JWTAudience,
-		"jti": randID(),
+	meta.AMR = rot.AMR
+	access, err := h.signAccess(ctx, rot.UserID, meta)
+	if err != nil {
+		http.Error(w, "server", http.StatusInternalServerError)
+		return
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	kid := jwtauth.CurrentKID()
-	sec, _ := jwtauth.Secret(kid)
-	t.Header["kid"] = kid
-	access, _ := t.SignedString(sec)
 
-	_ = json.NewEncoder(w).Encode(map[string]any{"access": access, "refresh": newRT})
+	_ = json.NewEncoder(w).Encode(map[string]any{"access": access, "refresh": rot.Token})
+}
+
+func (h Auth) reuseDetected(r *http.Request, rot repos.Rotation, m repos.SessionMeta) {
+	if h.Metrics != nil {
+		h.Metrics.Reuse.WithLabelValues("revoked").Inc()
+	}
+	for _, s := range rot.Reuse.Revoked {
+		revokeAccess(r.Context(), h.JTIStore, s)
+	}
+	recordEvent(h.Audit, r, rot.UserID, "refresh_reuse", http.StatusUnauthorized, map[string]any{
+		"family_id": rot.Reuse.FamilyID,
+		"first_ip":  rot.Reuse.FirstIP,
+		"first_ua":  rot.Reuse.FirstUA,
+		"first_at":  rot.Reuse.FirstAt,
+		"reuse_ip":  m.IP,
+		"reuse_ua":  m.UserAgent,
+		"revoked":   len(rot.Reuse.Revoked),
+	})
 }
 
 func (h Auth) Logout(w http.ResponseWriter, r *http.Request) {
@@ -183,18 +345,7 @@ func (h Auth) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 	raw := strings.TrimPrefix(hdr, "Bearer ")
 
-	keyFn := func(t *jwt.Token) (interface{}, error) {
-		if kid, _ := t.Header["kid"].(string); kid != "" {
-			if sec, ok := jwtauth.Secret(kid); ok {
-				return sec, nil
-			}
-		}
-		if sec, ok := jwtauth.Secret(jwtauth.CurrentKID()); ok {
-			return sec, nil
-		}
-		return nil, jwt.ErrTokenMalformed
-	}
-	tok, err := jwt.Parse(raw, keyFn)
+	tok, err := jwt.Parse(raw, jwtauth.Keyfunc)
 	if err != nil || !tok.Valid {
 		apperr.Write(w, r, apperr.Unauthorized)
 		return
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_truncate_KeepsRuneBoundaries(t *testing.T) {
+	s := truncate(strings.Repeat("ğ", 300), 255)
+	if !utf8.ValidString(s) || utf8.RuneCountInString(s) != 255 {
+		t.Fatalf("bad cut: valid=%v runes=%d", utf8.ValidString(s), utf8.RuneCountInString(s))
+	}
+	if got := truncate("ab\xffc", 10); !utf8.ValidString(got) {
+		t.Fatalf("invalid UTF-8 kept: %q", got)
+	}
+	if got := truncate("short", 100); got != "short" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+type jtiRevoker interface {
+	Revoke(ctx context.Context, jti string, ttl time.Duration) error
+}
+
+// revokeAccess revokes the access token last issued to a session.
+func revokeAccess(ctx context.Context, store jtiRevoker, s repos.Session) {
+	ttl := time.Until(s.AccessExp)
+	if store == nil || s.AccessJTI == "" || ttl <= 0 {
+		return
+	}
+	_ = store.Revoke(ctx, s.AccessJTI, ttl)
+}
+
+// revokeUser ends every session of uid, refresh and access tokens alike, and
+// deletes its personal access tokens.
+func revokeUser(ctx context.Context, tokens *repos.RefreshTokens, pats *repos.PATs, store jtiRevoker, uid int64) error {
+	ss, err := tokens.RevokeAll(ctx, uid)
+	for _, s := range ss {
+		revokeAccess(ctx, store, s)
+	}
+	if pats != nil {
+		if perr := pats.DeleteAll(ctx, uid); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// logoutAll bumps the token version of uid, which rejects every access token
+// issued so far, and deletes its refresh and personal access tokens so none
+// can be used again.
+func logoutAll(ctx context.Context, versions *repos.TokenVersions, tokens *repos.RefreshTokens, pats *repos.PATs, store jtiRevoker, uid int64) error {
+	var err error
+	if versions != nil {
+		_, err = versions.Bump(ctx, uid)
+	}
+	if rerr := revokeUser(ctx, tokens, pats, store, uid); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func recordEvent(a *repos.Audit, r *http.Request, uid int64, action string, status int, meta map[string]any) {
+	recordActorEvent(a, r, uid, 0, action, status, meta)
+}
+
+// recordAdminEvent records an action the authenticated admin took on user
+// uid (0 when no single user is affected).
+func recordAdminEvent(a *repos.Audit, r *http.Request, uid int64, action string, status int, meta map[string]any) {
+	actor, _ := middleware.UserID(r.Context())
+	recordActorEvent(a, r, uid, actor, action, status, meta)
+}
+
+func recordActorEvent(a *repos.Audit, r *http.Request, uid, actor int64, action string, status int, meta map[string]any) {
+	if a == nil {
+		return
+	}
+	if actor == 0 {
+		actor, _ = middleware.Actor(r.Context())
+	}
+	_ = a.Record(context.WithoutCancel(r.Context()), repos.AuditEvent{
+		UserID:  uid,
+		ActorID: actor,
+		Action:  action,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Status:  status,
+		IP:      strings.TrimPrefix(middleware.IPKey(r), "ip:"),
+		RID:     r.Header.Get("X-Request-ID"),
+		Meta:    meta,
+	})
+}
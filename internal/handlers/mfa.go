@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/Veysel440/go-notes-api/internal/security"
+	"github.com/Veysel440/go-notes-api/internal/totp"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaChallengeTTL = 5 * time.Minute
+	recoveryCodes   = 10
+)
+
+var errBadMFACode = apperr.Validation(map[string]string{"code": "invalid code"})
+
+// checkMFACode accepts a TOTP code (each step once) or an unused recovery code
+// and returns the amr value describing which one matched.
+func checkMFACode(ctx context.Context, m *repos.MFA, uid int64, code string) (string, error) {
+	st, err := m.Get(ctx, uid)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !st.Confirmed) {
+		return "", errBadMFACode
+	}
+	if err != nil {
+		return "", err
+	}
+	if step, ok := totp.Validate(st.Secret, code, time.Now(), 1); ok {
+		if fresh, err := m.UseStep(ctx, uid, step); err != nil || !fresh {
+			return "", errBadMFACode
+		}
+		return "otp", nil
+	}
+	if ok, err := m.UseRecovery(ctx, uid, code); err != nil || !ok {
+		return "", errBadMFACode
+	}
+	return "rcv", nil
+}
+
+func (h Auth) mfaAudience() string { return h.Cfg.JWTAudience + ":mfa" }
+
+// mfaChallenge answers a first-factor login with a short-lived token for
+// LoginMFA; via is the amr of the first factor ("pwd" or "oidc").
+func (h Auth) mfaChallenge(w http.ResponseWriter, uid int64, device, via string) {
+	now := time.Now()
+	tok, err := jwtauth.Sign(jwt.MapClaims{
+		"sub": uid,
+		"typ": "mfa",
+		"dev": device,
+		"via": via,
+		"iss": h.Cfg.JWTIssuer,
+		"aud": h.mfaAudience(),
+		"iat": now.Unix(),
+		"exp": now.Add(mfaChallengeTTL).Unix(),
+	})
+	if err != nil {
+		http.Error(w, "server", http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"mfa_required": true, "mfa_token": tok})
+}
+
+// LoginMFA completes a login that answered with an MFA challenge.
+func (h Auth) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token string `json:"mfa_token"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Token == "" || in.Code == "" || h.MFA == nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	tok, err := jwt.Parse(in.Token, jwtauth.Keyfunc, jwt.WithAudience(h.mfaAudience()), jwt.WithIssuer(h.Cfg.JWTIssuer), jwt.WithExpirationRequired())
+	if err != nil || !tok.Valid {
+		apperr.Write(w, r, apperr.Unauthorized)
+		return
+	}
+	claims, _ := tok.Claims.(jwt.MapClaims)
+	sub, ok := claims["sub"].(float64)
+	if typ, _ := claims["typ"].(string); typ != "mfa" || !ok {
+		apperr.Write(w, r, apperr.Unauthorized)
+		return
+	}
+	uid := int64(sub)
+	device, _ := claims["dev"].(string)
+	via, _ := claims["via"].(string)
+	if via == "" {
+		via = "pwd"
+	}
+
+	if !allowMFAAttempt(w, r, h.BruteRedis, uid) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	method, err := checkMFACode(ctx, h.MFA, uid, in.Code)
+	if err != nil {
+		if h.Metrics != nil {
+			h.Metrics.Failed.Inc()
+		}
+		apperr.Write(w, r, err)
+		return
+	}
+	if method == "rcv" {
+		recordEvent(h.Audit, r, uid, "mfa_recovery_used", http.StatusOK, nil)
+	}
+
+	meta := h.sessionMeta(r, device)
+	meta.AMR = via + "," + method + ",mfa"
+	h.issuePair(ctx, w, r, uid, meta)
+}
+
+// allowMFAAttempt shares one attempt budget per user across every endpoint
+// that accepts a TOTP or recovery code, so a stolen session cannot guess its
+// way past the second factor.
+func allowMFAAttempt(w http.ResponseWriter, r *http.Request, rdb *redis.Client, uid int64) bool {
+	if rdb == nil {
+		return true
+	}
+	br := security.Brute{RDB: rdb, Limit: 5, Window: 5 * time.Minute}
+	if ok, _, ttl := br.Allow(r.Context(), r, "mfa:"+strconv.FormatInt(uid, 10)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())))
+		apperr.Write(w, r, apperr.TooMany)
+		return false
+	}
+	return true
+}
+
+type MFA struct {
+	Cfg        config.Config
+	Users      *repos.Users
+	Repo       *repos.MFA
+	Audit      *repos.Audit
+	BruteRedis *redis.Client
+}
+
+func (h MFA) Routes(r chi.Router) {
+	r.Get("/mfa", h.Status)
+	r.Post("/mfa/totp", h.Enroll)
+	r.Post("/mfa/totp/confirm", h.Confirm)
+	r.Delete("/mfa/totp", h.Disable)
+	r.Post("/mfa/recovery-codes", h.Regenerate)
+}
+
+func (h MFA) Status(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	enabled, err := h.Repo.Enabled(ctx, uid)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	left := 0
+	if enabled {
+		left, _ = h.Repo.RecoveryLeft(ctx, uid)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"enabled": enabled, "recovery_codes_left": left})
+}
+
+func (h MFA) Enroll(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if enabled, err := h.Repo.Enabled(ctx, uid); err != nil || enabled {
+		apperr.Write(w, r, apperr.E(409, "mfa_already_enabled", "mfa already enabled", err, nil))
+		return
+	}
+	u, err := h.Users.FindByID(ctx, uid)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err == nil {
+		err = h.Repo.Begin(ctx, uid, secret)
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "mfa_failed", "server error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(h.Cfg.JWTIssuer, u.Email, secret),
+	})
+}
+
+func (h MFA) Confirm(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	if !allowMFAAttempt(w, r, h.BruteRedis, uid) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	st, err := h.Repo.Get(ctx, uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.E(409, "mfa_not_enrolled", "start enrollment first", nil, nil))
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if st.Confirmed {
+		apperr.Write(w, r, apperr.E(409, "mfa_already_enabled", "mfa already enabled", nil, nil))
+		return
+	}
+	step, ok := totp.Validate(st.Secret, in.Code, time.Now(), 1)
+	if !ok {
+		apperr.Write(w, r, errBadMFACode)
+		return
+	}
+	codes := repos.NewRecoveryCodes(recoveryCodes)
+	if err := h.Repo.Confirm(ctx, uid, step, codes); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "mfa_enabled", http.StatusOK, nil)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
+
+func (h MFA) Disable(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	if !allowMFAAttempt(w, r, h.BruteRedis, uid) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if _, err := checkMFACode(ctx, h.Repo, uid, in.Code); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.Repo.Disable(ctx, uid); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "mfa_disabled", http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h MFA) Regenerate(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	if !allowMFAAttempt(w, r, h.BruteRedis, uid) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if _, err := checkMFACode(ctx, h.Repo, uid, in.Code); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	codes := repos.NewRecoveryCodes(recoveryCodes)
+	if err := h.Repo.ReplaceRecovery(ctx, uid, codes); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "mfa_recovery_regenerated", http.StatusOK, nil)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
@@ -6,13 +6,16 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	apperr "github.com/Veysel440/go-notes-api/internal/errors"
 	"github.com/Veysel440/go-notes-api/internal/middleware"
@@ -20,11 +23,22 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-type Notes struct{ Repo *repos.Notes }
+type Notes struct {
+	Repo *repos.Notes
+	// RequireBase rejects updates that name no base version with 428
+	// instead of overwriting the note.
+	RequireBase bool
+	// CreateGuard, when set, wraps note creation (e.g. RequireVerified).
+	CreateGuard func(http.Handler) http.Handler
+}
 
 func (h Notes) Routes(r chi.Router) {
 	r.Get("/", h.list)
-	r.Post("/", h.create)
+	if h.CreateGuard != nil {
+		r.With(h.CreateGuard).Post("/", h.create)
+	} else {
+		r.Post("/", h.create)
+	}
 	r.Route("/{id}", func(rr chi.Router) {
 		rr.Get("/", h.get)
 		rr.Put("/", h.update)
@@ -32,58 +46,147 @@ func (h Notes) Routes(r chi.Router) {
 	})
 }
 
-func collETag(page, size int, q, sort string, items []repos.Note) string {
-	var maxID int64
-	for i := range items {
-		if items[i].ID > maxID {
-			maxID = items[i].ID
-		}
-	}
-	sum := crc32.ChecksumIEEE([]byte(strings.ToLower(q) + "|" + sort))
-	return fmt.Sprintf(`W/"notes-%d-%d-%d-%d-%08x"`, maxID, len(items), page, size, sum)
+func collETag(nq repos.NoteQuery, v repos.NoteVersion) string {
+	sum := crc32.ChecksumIEEE([]byte(nq.Key()))
+	return fmt.Sprintf(`W/"notes-%d-%d-%d-%d-%08x"`, v.Version, v.UpdatedAt.UnixMicro(), nq.Page, nq.Size, sum)
 }
 
 func noteETag(n repos.Note) string {
-	ts := n.UpdatedAt
-	if ts.IsZero() {
-		ts = n.CreatedAt
-	}
+	ts := noteModified(n)
 	h := sha256.Sum256([]byte(n.Title + "|" + n.Body))
-	return fmt.Sprintf(`W/"n-%d-%d-%s"`, n.ID, ts.Unix(), hex.EncodeToString(h[:4]))
+	return fmt.Sprintf(`W/"n-%d-%d-%s-v%d"`, n.ID, ts.Unix(), hex.EncodeToString(h[:4]), n.Version)
+}
+
+func etagVersion(etag string) int {
+	etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+	i := strings.LastIndex(etag, "-v")
+	if !strings.HasPrefix(etag, "n-") || i < 0 {
+		return 0
+	}
+	v, _ := strconv.Atoi(etag[i+2:])
+	return v
+}
+
+func noteModified(n repos.Note) time.Time {
+	if n.UpdatedAt.IsZero() {
+		return n.CreatedAt
+	}
+	return n.UpdatedAt
+}
+
+func parseNoteQuery(r *http.Request) (repos.NoteQuery, map[string]string) {
+	qs := r.URL.Query()
+	nq := repos.NoteQuery{Q: qs.Get("q"), Sort: qs.Get("sort"), TitlePrefix: qs.Get("title_prefix")}
+	nq.Page, _ = strconv.Atoi(qs.Get("page"))
+	if nq.Page < 1 {
+		nq.Page = 1
+	}
+	nq.Size, _ = strconv.Atoi(qs.Get("size"))
+	if nq.Size < 1 || nq.Size > 100 {
+		nq.Size = 20
+	}
+
+	fields := map[string]string{}
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &nq.CreatedAfter,
+		"created_before": &nq.CreatedBefore,
+		"updated_after":  &nq.UpdatedAfter,
+		"updated_before": &nq.UpdatedBefore,
+	} {
+		v := qs.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			fields[name] = "must be an RFC 3339 timestamp"
+			continue
+		}
+		*dst = t
+	}
+	if !nq.CreatedAfter.IsZero() && !nq.CreatedBefore.IsZero() && !nq.CreatedAfter.Before(nq.CreatedBefore) {
+		fields["created_before"] = "must be after created_after"
+	}
+	if !nq.UpdatedAfter.IsZero() && !nq.UpdatedBefore.IsZero() && !nq.UpdatedAfter.Before(nq.UpdatedBefore) {
+		fields["updated_before"] = "must be after updated_after"
+	}
+	if utf8.RuneCountInString(nq.TitlePrefix) > 200 {
+		fields["title_prefix"] = "must be at most 200 characters"
+	}
+	if v := qs.Get("fields"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" || slices.Contains(nq.Fields, f) {
+				continue
+			}
+			if !slices.Contains(repos.NoteFields, f) {
+				fields["fields"] = "unknown field " + strconv.Quote(f)
+				break
+			}
+			nq.Fields = append(nq.Fields, f)
+		}
+	}
+	if v := qs.Get("excerpt"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			fields["excerpt"] = "must be an integer between 1 and 10000"
+		} else {
+			nq.Excerpt = n
+		}
+	}
+	if v := qs.Get("has_body"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			fields["has_body"] = "must be true or false"
+		} else {
+			nq.HasBody = &b
+		}
+	}
+	if len(fields) > 0 {
+		return nq, fields
+	}
+	return nq, nil
 }
 
 func (h Notes) list(w http.ResponseWriter, r *http.Request) {
 	uid, _ := middleware.UserID(r.Context())
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
-	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
-	if size < 1 || size > 100 {
-		size = 20
+	nq, bad := parseNoteQuery(r)
+	if bad != nil {
+		apperr.Write(w, r, apperr.Validation(bad))
+		return
 	}
-	q := r.URL.Query().Get("q")
-	sort := r.URL.Query().Get("sort")
 
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
-	items, total, err := h.Repo.ListFiltered(ctx, uid, page, size, q, sort)
+	ver, err := h.Repo.Version(ctx, uid)
 	if err != nil {
 		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
 		return
 	}
-
-	etag := collETag(page, size, q, sort, items)
-	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+	etag := collETag(nq, ver)
+	setValidators(w, etag, ver.UpdatedAt)
+	if notModified(r, etag, ver.UpdatedAt) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
 
+	items, total, err := h.Repo.ListFiltered(ctx, uid, nq)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+
+	var out any = items
+	if len(nq.Fields) > 0 {
+		proj := make([]map[string]any, len(items))
+		for i := range items {
+			proj[i] = items[i].Project(nq.Fields)
+		}
+		out = proj
+	}
 	_ = json.NewEncoder(w).Encode(map[string]any{
-		"items": items, "total": total, "page": page, "size": size,
+		"items": out, "total": total, "page": nq.Page, "size": nq.Size,
 	})
 }
 
@@ -105,12 +208,11 @@ func (h Notes) get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	etag := noteETag(n)
-	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+	setValidators(w, etag, noteModified(n))
+	if notModified(r, etag, noteModified(n)) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
 	_ = json.NewEncoder(w).Encode(n)
 }
 
@@ -137,7 +239,7 @@ func (h Notes) create(w http.ResponseWriter, r *http.Request) {
 		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
 		return
 	}
-	w.Header().Set("ETag", noteETag(n))
+	setValidators(w, noteETag(n), noteModified(n))
 	_ = json.NewEncoder(w).Encode(n)
 }
 
@@ -156,11 +258,23 @@ func (h Notes) update(w http.ResponseWriter, r *http.Request) {
 		raw, _ = io.ReadAll(io.LimitReader(r.Body, 1<<20))
 		r.Body = io.NopCloser(bytes.NewReader(raw))
 	}
-	var in struct{ Title, Body string }
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+	var in struct {
+		Title       string `json:"title"`
+		Body        string `json:"body"`
+		BaseVersion int    `json:"base_version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.BaseVersion < 0 {
 		apperr.Write(w, r, apperr.BadRequest)
 		return
 	}
+	if in.BaseVersion == 0 {
+		ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+		in.BaseVersion = etagVersion(ifMatch)
+		if h.RequireBase && in.BaseVersion == 0 && ifMatch != "*" {
+			apperr.Write(w, r, apperr.E(http.StatusPreconditionRequired, "precondition_required", "base_version or If-Match required", nil, nil))
+			return
+		}
+	}
 
 	if key != "" {
 		sum := sha256.Sum256(raw)
@@ -189,13 +303,29 @@ func (h Notes) update(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
-	n, err := h.Repo.Update(ctx, uid, id64, strings.TrimSpace(in.Title), in.Body)
-	if err != nil {
+	n, merged, err := h.Repo.Update(ctx, uid, id64, in.BaseVersion, strings.TrimSpace(in.Title), in.Body)
+	var mc *repos.MergeConflict
+	switch {
+	case errors.As(err, &mc):
+		w.Header().Set("ETag", noteETag(mc.Current))
+		apperr.Write(w, r, &apperr.AppError{Status: http.StatusConflict, Code: "merge_conflict", Message: "merge conflict", Details: mc})
+		return
+	case errors.Is(err, repos.ErrBaseUnknown):
+		apperr.Write(w, r, apperr.E(http.StatusConflict, "base_unknown", "base version not available", err, nil))
+		return
+	case errors.Is(err, repos.ErrNoteBusy):
+		w.Header().Set("Retry-After", "1")
+		apperr.Write(w, r, apperr.E(http.StatusConflict, "note_busy", "note is being edited, retry", err, nil))
+		return
+	case err != nil:
 		apperr.Write(w, r, apperr.NotFound)
 		return
 	}
 
-	w.Header().Set("ETag", noteETag(n))
+	if merged {
+		w.Header().Set("X-Merged", "true")
+	}
+	setValidators(w, noteETag(n), noteModified(n))
 	resp, _ := json.Marshal(n)
 	if key != "" {
 		idem := repos.Idem{DB: h.Repo.DB}
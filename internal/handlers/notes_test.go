@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/go-chi/chi/v5"
 )
 
 func Test_noteETag_ChangesWithUpdate(t *testing.T) {
@@ -22,12 +27,9 @@ func Test_noteETag_ChangesWithUpdate(t *testing.T) {
 }
 
 func Test_collETag_DiffersWithParams(t *testing.T) {
-	items := []repos.Note{
-		{ID: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		{ID: 5, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-	}
-	e1 := collETag(1, 20, "a", "id", items)
-	e2 := collETag(2, 20, "a", "id", items)
+	v := repos.NoteVersion{Version: 3, UpdatedAt: time.Now()}
+	e1 := collETag(repos.NoteQuery{Page: 1, Size: 20, Q: "a", Sort: "id"}, v)
+	e2 := collETag(repos.NoteQuery{Page: 2, Size: 20, Q: "a", Sort: "id"}, v)
 	if e1 == e2 {
 		t.Fatalf("etag should differ for different page; %s", e1)
 	}
@@ -46,8 +48,92 @@ func Test_noteETag_Changes(t *testing.T) {
 }
 
 func Test_collETag_DiffersByParams(t *testing.T) {
-	items := []repos.Note{{ID: 1}, {ID: 5}}
-	if collETag(1, 20, "q", "id", items) == collETag(2, 20, "q", "id", items) {
+	v := repos.NoteVersion{Version: 1}
+	if collETag(repos.NoteQuery{Page: 1, Size: 20, Q: "q", Sort: "id"}, v) ==
+		collETag(repos.NoteQuery{Page: 2, Size: 20, Q: "q", Sort: "id"}, v) {
 		t.Fatal("collection etag must differ by page")
 	}
 }
+
+func Test_collETag_DiffersByFilter(t *testing.T) {
+	v := repos.NoteVersion{Version: 1}
+	base := repos.NoteQuery{Page: 1, Size: 20}
+	filtered := base
+	filtered.UpdatedAfter = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if collETag(base, v) == collETag(filtered, v) {
+		t.Fatal("collection etag must differ by filter")
+	}
+}
+
+func Test_parseNoteQuery_Errors(t *testing.T) {
+	r := httptest.NewRequest("GET", "/notes?created_after=yesterday&has_body=maybe&updated_after=2024-03-02T00:00:00Z&updated_before=2024-03-01T00:00:00Z", nil)
+	_, bad := parseNoteQuery(r)
+	for _, f := range []string{"created_after", "has_body", "updated_before"} {
+		if _, ok := bad[f]; !ok {
+			t.Fatalf("want field error for %s, got %v", f, bad)
+		}
+	}
+
+	r = httptest.NewRequest("GET", "/notes?created_after=2024-03-01T00:00:00Z&title_prefix=a_b&has_body=true", nil)
+	nq, bad := parseNoteQuery(r)
+	if bad != nil || nq.CreatedAfter.IsZero() || nq.HasBody == nil || !*nq.HasBody || nq.TitlePrefix != "a_b" {
+		t.Fatalf("unexpected parse: %+v %v", nq, bad)
+	}
+
+	r = httptest.NewRequest("GET", "/notes?title_prefix="+url.QueryEscape(strings.Repeat("ş", 200)), nil)
+	if _, bad := parseNoteQuery(r); bad != nil {
+		t.Fatalf("200 characters must be accepted, got %v", bad)
+	}
+}
+
+func Test_collETag_ChangesWithVersion(t *testing.T) {
+	nq := repos.NoteQuery{Page: 1, Size: 20}
+	ts := time.Unix(1000, 0)
+	if collETag(nq, repos.NoteVersion{Version: 1, UpdatedAt: ts}) == collETag(nq, repos.NoteVersion{Version: 2, UpdatedAt: ts}) {
+		t.Fatal("collection etag must change when the change counter moves")
+	}
+}
+
+func Test_notModified(t *testing.T) {
+	etag := `W/"n-1-1000-abcd"`
+	lm := time.Unix(1000, 0)
+	cases := []struct {
+		hdr, val string
+		want     bool
+	}{
+		{"If-None-Match", etag, true},
+		{"If-None-Match", `"x", "n-1-1000-abcd"`, true},
+		{"If-None-Match", "*", true},
+		{"If-None-Match", `W/"other"`, false},
+		{"If-Modified-Since", lm.UTC().Format(http.TimeFormat), true},
+		{"If-Modified-Since", lm.Add(-time.Second).UTC().Format(http.TimeFormat), false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/notes/1", nil)
+		r.Header.Set(c.hdr, c.val)
+		if got := notModified(r, etag, lm); got != c.want {
+			t.Fatalf("%s: %q want %v got %v", c.hdr, c.val, c.want, got)
+		}
+	}
+}
+
+func Test_etagVersion_RoundTrip(t *testing.T) {
+	n := repos.Note{ID: 4, Title: "t", Body: "b", Version: 12, UpdatedAt: time.Unix(1000, 0)}
+	if v := etagVersion(noteETag(n)); v != 12 {
+		t.Fatalf("want 12, got %d", v)
+	}
+	if v := etagVersion("*"); v != 0 {
+		t.Fatalf("want 0 for *, got %d", v)
+	}
+}
+
+func Test_update_RequiresBaseVersion(t *testing.T) {
+	rt := chi.NewRouter()
+	rt.Put("/notes/{id}", Notes{RequireBase: true}.update)
+
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, httptest.NewRequest("PUT", "/notes/1", strings.NewReader(`{"title":"t","body":"b"}`)))
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Fatalf("want 428, got %d", rr.Code)
+	}
+}
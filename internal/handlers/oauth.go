@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oauthCodeTTL = time.Minute
+
+// OAuth is the authorization server for third-party clients: authorization
+// code flow with PKCE (S256 only), refresh, revocation (RFC 7009) and
+// introspection (RFC 7662). Tokens come from the same machinery as logins;
+// they carry client_id and scope claims and are confined to /notes.
+type OAuth struct {
+	Auth    Auth
+	Repo    *repos.OAuth
+	Revoked interface {
+		IsRevoked(ctx context.Context, jti string) (bool, error)
+	}
+}
+
+// Routes mounts the protocol endpoints. /authorize expects an authenticated
+// session, the others authenticate the client.
+func (h OAuth) Routes(r chi.Router, authn func(http.Handler) http.Handler) {
+	r.Route("/oauth", func(or chi.Router) {
+		or.With(authn, middleware.RequireScope(), middleware.NoImpersonation).Get("/authorize", h.AuthorizeInfo)
+		or.With(authn, middleware.RequireScope(), middleware.NoImpersonation).Post("/authorize", h.Authorize)
+		or.Post("/token", h.Token)
+		or.Post("/revoke", h.Revoke)
+		or.Post("/introspect", h.Introspect)
+	})
+}
+
+// MeRoutes mounts client registration and consent management under /me.
+func (h OAuth) MeRoutes(r chi.Router) {
+	r.Get("/oauth/clients", h.ListClients)
+	r.Post("/oauth/clients", h.CreateClient)
+	r.Delete("/oauth/clients/{client_id}", h.DeleteClient)
+	r.Get("/oauth/consents", h.ListConsents)
+	r.Delete("/oauth/consents/{client_id}", h.DeleteConsent)
+}
+
+type authzRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// check validates an authorization request. Problems with the client or the
+// redirect URI are returned as err, since nothing may be sent to an
+// unverified redirect; the rest come back as an OAuth error code to be
+// delivered to the client's redirect URI.
+func (h OAuth) check(ctx context.Context, in *authzRequest) (c repos.OAuthClient, oauthErr string, err error) {
+	c, err = h.Repo.Client(ctx, in.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, "", apperr.E(400, "invalid_client", "unknown client_id", nil, nil)
+	}
+	if err != nil {
+		return c, "", apperr.E(500, "db_error", "db error", err, nil)
+	}
+	if in.RedirectURI == "" && len(c.RedirectURIs) == 1 {
+		in.RedirectURI = c.RedirectURIs[0]
+	}
+	if !slices.Contains(c.RedirectURIs, in.RedirectURI) {
+		return c, "", apperr.E(400, "invalid_redirect_uri", "redirect_uri is not registered for this client", nil, nil)
+	}
+	switch {
+	case in.ResponseType != "code":
+		return c, "unsupported_response_type", nil
+	case in.CodeChallengeMethod != "S256" || len(in.CodeChallenge) != 43:
+		return c, "invalid_request", nil
+	}
+	scopes := strings.Fields(in.Scope)
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+	for _, s := range scopes {
+		if !slices.Contains(c.Scopes, s) {
+			return c, "invalid_scope", nil
+		}
+	}
+	slices.Sort(scopes)
+	in.Scope = strings.Join(slices.Compact(scopes), " ")
+	return c, "", nil
+}
+
+func redirectWith(uri string, params map[string]string) string {
+	u, _ := url.Parse(uri)
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// AuthorizeInfo describes a pending authorization request so the frontend can
+// render the consent screen.
+func (h OAuth) AuthorizeInfo(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	q := r.URL.Query()
+	in := authzRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, oauthErr, err := h.check(ctx, &in)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if oauthErr != "" {
+		apperr.Write(w, r, apperr.E(400, oauthErr, "invalid authorization request", nil, nil))
+		return
+	}
+	granted, err := h.Repo.Consent(ctx, uid, c.ClientID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"client":       map[string]string{"client_id": c.ClientID, "name": c.Name},
+		"redirect_uri": in.RedirectURI,
+		"scope":        in.Scope,
+		"consented":    granted != "" && coversScope(granted, in.Scope),
+	})
+}
+
+func coversScope(granted, want string) bool {
+	have := strings.Fields(granted)
+	for _, s := range strings.Fields(want) {
+		if !slices.Contains(have, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorize records the user's decision and answers with the URI the user
+// agent should be sent to: the client's redirect URI carrying either a code
+// or an error.
+func (h OAuth) Authorize(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in authzRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, oauthErr, err := h.check(ctx, &in)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if oauthErr == "" && !in.Approve {
+		oauthErr = "access_denied"
+	}
+	redirect := func(params map[string]string) {
+		params["state"] = in.State
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"redirect_to": redirectWith(in.RedirectURI, params)})
+	}
+	if oauthErr != "" {
+		redirect(map[string]string{"error": oauthErr})
+		return
+	}
+
+	if err := h.Repo.SaveConsent(ctx, uid, c.ClientID, in.Scope); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	code, err := h.Repo.CreateCode(ctx, repos.OAuthCode{
+		ClientID: c.ClientID, UserID: uid, RedirectURI: in.RedirectURI, Scope: in.Scope, CodeChallenge: in.CodeChallenge,
+	}, oauthCodeTTL)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Auth.Audit, r, uid, "oauth_consent", http.StatusOK, map[string]any{"client_id": c.ClientID, "scope": in.Scope})
+	redirect(map[string]string{"code": code})
+}
+
+// oauthError writes an RFC 6749 section 5.2 error response.
+func oauthError(w http.ResponseWriter, status int, code, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": desc})
+}
+
+// client authenticates the calling client from HTTP Basic or the
+// client_id/client_secret form fields. Public clients send only client_id.
+func (h OAuth) client(ctx context.Context, r *http.Request) (repos.OAuthClient, bool) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id == "" {
+		return repos.OAuthClient{}, false
+	}
+	c, err := h.Repo.Client(ctx, id)
+	if err != nil {
+		return c, false
+	}
+	if c.Confidential {
+		return c, c.CheckSecret(secret)
+	}
+	return c, secret == ""
+}
+
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(challenge)) == 1
+}
+
+func (h OAuth) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "form body expected")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, ok := h.client(ctx, r)
+	if !ok {
+		oauthError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	meta := h.Auth.sessionMeta(r, c.Name)
+	meta.ClientID = c.ClientID
+
+	var uid int64
+	var refresh string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := h.Repo.ConsumeCode(ctx, r.PostForm.Get("code"), c.ClientID)
+		if errors.Is(err, sql.ErrNoRows) {
+			oauthError(w, 400, "invalid_grant", "code is invalid, expired or already used")
+			return
+		}
+		if err != nil {
+			oauthError(w, 500, "server_error", "")
+			return
+		}
+		redirect := r.PostForm.Get("redirect_uri")
+		if (redirect != "" && redirect != code.RedirectURI) || !verifyPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+			oauthError(w, 400, "invalid_grant", "redirect_uri or code_verifier mismatch")
+			return
+		}
+		uid, meta.Scope = code.UserID, code.Scope
+		refresh, err = h.Auth.Tokens.Issue(ctx, uid, time.Now().Add(h.Auth.Cfg.RefreshTTL), meta)
+		if err != nil {
+			oauthError(w, 500, "server_error", "")
+			return
+		}
+	case "refresh_token":
+		rot, err := h.Auth.Tokens.UseAndRotate(ctx, r.PostForm.Get("refresh_token"), time.Now().Add(h.Auth.Cfg.RefreshTTL), meta)
+		if errors.Is(err, sql.ErrNoRows) {
+			oauthError(w, 400, "invalid_grant", "refresh token is invalid")
+			return
+		}
+		if err != nil {
+			oauthError(w, 500, "server_error", "")
+			return
+		}
+		if rot.Reuse != nil {
+			h.Auth.reuseDetected(r, rot, meta)
+			oauthError(w, 400, "invalid_grant", "refresh token reuse detected")
+			return
+		}
+		uid, refresh, meta.Scope = rot.UserID, rot.Token, rot.Scope
+	default:
+		oauthError(w, 400, "unsupported_grant_type", "")
+		return
+	}
+
+	if h.Auth.blocked(ctx, uid) != nil {
+		oauthError(w, 400, "invalid_grant", "account is not active")
+		return
+	}
+	access, err := h.Auth.signAccess(ctx, uid, meta)
+	if err != nil {
+		oauthError(w, 500, "server_error", "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(h.Auth.Cfg.JWTTTL.Seconds()),
+		"refresh_token": refresh,
+		"scope":         meta.Scope,
+	})
+}
+
+// accessClaims parses an access token issued by this server, without
+// consulting the revocation list.
+func (h OAuth) accessClaims(raw string) (jwt.MapClaims, bool) {
+	tok, err := jwt.Parse(raw, jwtauth.Keyfunc, jwt.WithAudience(h.Auth.Cfg.JWTAudience), jwt.WithIssuer(h.Auth.Cfg.JWTIssuer))
+	if err != nil || !tok.Valid {
+		return nil, false
+	}
+	claims, ok := tok.Claims.(jwt.MapClaims)
+	return claims, ok
+}
+
+// Revoke implements RFC 7009. Unknown tokens and tokens of other clients are
+// ignored and still answered with 200.
+func (h OAuth) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "form body expected")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, ok := h.client(ctx, r)
+	if !ok {
+		oauthError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if claims, ok := h.accessClaims(token); ok {
+		if cid, _ := claims["client_id"].(string); cid == c.ClientID && h.Auth.JTIStore != nil {
+			jti, _ := claims["jti"].(string)
+			exp, _ := claims.GetExpirationTime()
+			if exp != nil && jti != "" {
+				_ = h.Auth.JTIStore.Revoke(ctx, jti, time.Until(exp.Time))
+			}
+		}
+	} else if ss, err := h.Auth.Tokens.RevokeFamily(ctx, token, c.ClientID); err == nil {
+		for _, s := range ss {
+			revokeAccess(ctx, h.Auth.JTIStore, s)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Introspect implements RFC 7662 for confidential clients, which only learn
+// about their own tokens.
+func (h OAuth) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, 400, "invalid_request", "form body expected")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, ok := h.client(ctx, r)
+	if !ok || !c.Confidential {
+		oauthError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	out := map[string]any{"active": false}
+	token := r.PostForm.Get("token")
+	if claims, ok := h.accessClaims(token); ok {
+		jti, _ := claims["jti"].(string)
+		revoked := false
+		if h.Revoked != nil {
+			revoked, _ = h.Revoked.IsRevoked(ctx, jti)
+		}
+		if cid, _ := claims["client_id"].(string); cid == c.ClientID && !revoked {
+			out = map[string]any{
+				"active": true, "token_type": "access_token", "client_id": cid,
+				"scope": claims["scope"], "sub": claims["sub"], "exp": claims["exp"], "iat": claims["iat"],
+				"iss": claims["iss"], "aud": claims["aud"], "jti": jti,
+			}
+		}
+	} else if ri, err := h.Auth.Tokens.Lookup(ctx, token); err == nil && ri.ClientID == c.ClientID {
+		out = map[string]any{
+			"active": true, "token_type": "refresh_token", "client_id": ri.ClientID,
+			"scope": ri.Scope, "sub": ri.UserID, "exp": ri.ExpiresAt.Unix(), "iat": ri.CreatedAt.Unix(),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// validRedirect accepts absolute https URIs, plus http on loopback hosts for
+// native apps, without fragments.
+func validRedirect(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func (h OAuth) CreateClient(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	fields := map[string]string{}
+	if in.Name == "" || len(in.Name) > 100 {
+		fields["name"] = "required, max 100 characters"
+	}
+	if len(in.RedirectURIs) == 0 || len(in.RedirectURIs) > 5 {
+		fields["redirect_uris"] = "1 to 5 URIs"
+	}
+	for _, u := range in.RedirectURIs {
+		if !validRedirect(u) {
+			fields["redirect_uris"] = "must be https (http only for loopback) without fragment: " + u
+		}
+	}
+	if len(in.Scopes) == 0 {
+		fields["scopes"] = "at least one of " + strings.Join(repos.OAuthScopes, ", ")
+	}
+	for _, s := range in.Scopes {
+		if !slices.Contains(repos.OAuthScopes, s) {
+			fields["scopes"] = "unknown scope " + s
+		}
+	}
+	if len(fields) > 0 {
+		apperr.Write(w, r, apperr.Validation(fields))
+		return
+	}
+	slices.Sort(in.Scopes)
+	in.Scopes = slices.Compact(in.Scopes)
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	c, secret, err := h.Repo.CreateClient(ctx, uid, in.Name, in.RedirectURIs, in.Scopes, in.Confidential)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Auth.Audit, r, uid, "oauth_client_created", http.StatusCreated, map[string]any{"client_id": c.ClientID})
+	out := map[string]any{"item": c}
+	if secret != "" {
+		out["client_secret"] = secret
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (h OAuth) ListClients(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	items, err := h.Repo.Clients(ctx, uid)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func (h OAuth) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	id := chi.URLParam(r, "client_id")
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	if err := h.Repo.DeleteClient(ctx, uid, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			apperr.Write(w, r, apperr.NotFound)
+			return
+		}
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	ss, err := h.Auth.Tokens.RevokeClientAll(ctx, id)
+	for _, s := range ss {
+		revokeAccess(ctx, h.Auth.JTIStore, s)
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Auth.Audit, r, uid, "oauth_client_deleted", http.StatusNoContent, map[string]any{"client_id": id, "revoked": len(ss)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h OAuth) ListConsents(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	items, err := h.Repo.Consents(ctx, uid)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+// DeleteConsent withdraws the user's grant to a client and revokes every
+// token the client holds for the user.
+func (h OAuth) DeleteConsent(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	id := chi.URLParam(r, "client_id")
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	if err := h.Repo.DeleteConsent(ctx, uid, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			apperr.Write(w, r, apperr.NotFound)
+			return
+		}
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	ss, err := h.Auth.Tokens.RevokeClient(ctx, uid, id)
+	for _, s := range ss {
+		revokeAccess(ctx, h.Auth.JTIStore, s)
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Auth.Audit, r, uid, "oauth_consent_revoked", http.StatusNoContent, map[string]any{"client_id": id, "revoked": len(ss)})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !verifyPKCE(challenge, verifier) {
+		t.Fatal("rfc vector rejected")
+	}
+	if verifyPKCE(challenge, verifier[:42]) || verifyPKCE(challenge, strings.ToUpper(verifier)) {
+		t.Fatal("wrong verifier accepted")
+	}
+}
+
+func TestValidRedirect(t *testing.T) {
+	for uri, want := range map[string]bool{
+		"https://app.example/cb":      true,
+		"http://127.0.0.1:8765/cb":    true,
+		"http://localhost/cb":         true,
+		"http://app.example/cb":       false,
+		"https://app.example/cb#frag": false,
+		"myapp:/cb":                   false,
+	} {
+		if got := validRedirect(uri); got != want {
+			t.Fatalf("%s: want %v", uri, want)
+		}
+	}
+}
+
+func TestOAuth_TokenExchangesCodeForScopedPair(t *testing.T) {
+	withTestKeys(t)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", JWTTTL: time.Minute, RefreshTTL: time.Hour, DBTimeout: time.Second}
+	h := OAuth{Auth: Auth{Cfg: cfg, Tokens: &repos.RefreshTokens{DB: db}}, Repo: &repos.OAuth{DB: db}}
+
+	sum := sha256.Sum256([]byte("code1"))
+	clientRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "name", "redirect_uris", "scopes", "owner_id", "created_at"}).
+			AddRow(1, "gnc_pub", "", "Partner", "https://app.example/cb", "notes:read notes:write", 2, time.Now())
+	}
+	expectCode := func() {
+		mock.ExpectQuery(regexp.QuoteMeta("FROM oauth_clients WHERE client_id=?")).WithArgs("gnc_pub").WillReturnRows(clientRow())
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("FROM oauth_codes")).
+			WithArgs(hex.EncodeToString(sum[:]), "gnc_pub").
+			WillReturnRows(sqlmock.NewRows([]string{"client_id", "user_id", "redirect_uri", "scope", "code_challenge"}).
+				AddRow("gnc_pub", int64(9), "https://app.example/cb", "notes:read", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE oauth_codes SET used_at=NOW()")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.Token(rec, req)
+		return rec
+	}
+	form := url.Values{
+		"grant_type": {"authorization_code"}, "client_id": {"gnc_pub"}, "code": {"code1"},
+		"redirect_uri": {"https://app.example/cb"}, "code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"},
+	}
+
+	expectCode()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WithArgs(sqlmock.AnyArg(), int64(9), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Partner",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "gnc_pub", "notes:read").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	rec := post(form)
+	if rec.Code != 200 || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("token: %d %s", rec.Code, rec.Body)
+	}
+	var out struct {
+		Access  string `json:"access_token"`
+		Refresh string `json:"refresh_token"`
+		Scope   string `json:"scope"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(out.Access, claims, jwtauth.Keyfunc); err != nil {
+		t.Fatal(err)
+	}
+	if claims["scope"] != "notes:read" || claims["client_id"] != "gnc_pub" || out.Refresh == "" || out.Scope != "notes:read" {
+		t.Fatalf("unexpected response: %v %+v", claims, out)
+	}
+
+	expectCode()
+	form.Set("code_verifier", strings.Repeat("x", 43))
+	if rec := post(form); rec.Code != 400 || !strings.Contains(rec.Body.String(), "invalid_grant") {
+		t.Fatalf("pkce mismatch: %d %s", rec.Code, rec.Body)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM oauth_clients WHERE client_id=?")).WithArgs("gnc_pub").WillReturnRows(clientRow())
+	form.Set("client_secret", "guess")
+	if rec := post(form); rec.Code != 401 {
+		t.Fatalf("public client with secret: %d", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/oidc"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcCookie  = "oidc_flow"
+	oidcFlowTTL = 10 * time.Minute
+)
+
+// OIDC signs users in through an external OpenID Connect provider. The
+// state, nonce and PKCE verifier of a pending login travel in a signed
+// cookie scoped to /auth/oidc.
+type OIDC struct {
+	Auth       Auth
+	Provider   *oidc.Provider
+	Identities *repos.Identities
+}
+
+func (h OIDC) Routes(r chi.Router) {
+	r.Get("/oidc/login", h.Login)
+	r.Get("/oidc/callback", h.Callback)
+}
+
+func (h OIDC) flowAudience() string { return h.Auth.Cfg.JWTAudience + ":oidc" }
+
+func (h OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := oidc.NewVerifier(), oidc.NewVerifier(), oidc.NewVerifier()
+	uri, err := h.Provider.AuthURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(502, "oidc_unavailable", "identity provider unavailable", err, nil))
+		return
+	}
+	now := time.Now()
+	flow, err := jwtauth.Sign(jwt.MapClaims{
+		"typ": "oidc",
+		"st":  state,
+		"nn":  nonce,
+		"cv":  verifier,
+		"dev": r.URL.Query().Get("device_name"),
+		"iss": h.Auth.Cfg.JWTIssuer,
+		"aud": h.flowAudience(),
+		"iat": now.Unix(),
+		"exp": now.Add(oidcFlowTTL).Unix(),
+	})
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "server error", err, nil))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcCookie, Value: flow, Path: "/auth/oidc", MaxAge: int(oidcFlowTTL.Seconds()),
+		HttpOnly: true, Secure: strings.HasPrefix(h.Auth.Cfg.AppBaseURL, "https://"), SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, uri, http.StatusFound)
+}
+
+// Callback completes the login: the code is redeemed, the ID token verified
+// and the identity resolved to a local user, whose IdP-managed roles are then
+// synced before the usual token pair is issued. Accounts with local MFA get
+// the MFA challenge instead unless OIDC_TRUST_AMR accepts the IdP's own.
+func (h OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(400, "oidc_state", "login flow expired, start again", nil, nil))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/auth/oidc", MaxAge: -1})
+	flow := jwt.MapClaims{}
+	tok, err := jwt.ParseWithClaims(c.Value, flow, jwtauth.Keyfunc, jwt.WithAudience(h.flowAudience()), jwt.WithIssuer(h.Auth.Cfg.JWTIssuer), jwt.WithExpirationRequired())
+	state, _ := flow["st"].(string)
+	if err != nil || !tok.Valid || flow["typ"] != "oidc" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		apperr.Write(w, r, apperr.E(400, "oidc_state", "login flow expired, start again", nil, nil))
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		apperr.Write(w, r, apperr.E(401, "oidc_denied", "identity provider: "+e, nil, nil))
+		return
+	}
+	nonce, _ := flow["nn"].(string)
+	verifier, _ := flow["cv"].(string)
+	device, _ := flow["dev"].(string)
+
+	raw, err := h.Provider.Exchange(r.Context(), q.Get("code"), verifier)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(401, "oidc_exchange", "code exchange failed", err, nil))
+		return
+	}
+	claims, err := h.Provider.Verify(r.Context(), raw, nonce)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(401, "oidc_id_token", "invalid id token", err, nil))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Auth.Cfg.DBTimeout)
+	defer cancel()
+
+	uid, how, err := h.resolve(ctx, claims)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.syncRoles(ctx, uid, claims.Groups); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Auth.Audit, r, uid, "oidc_login", http.StatusOK, map[string]any{
+		"issuer": h.Provider.Issuer, "subject": claims.Subject, "account": how,
+	})
+
+	idpMFA := h.Auth.Cfg.OIDCTrustAMR && slices.Contains(claims.AMR, "mfa")
+	if h.Auth.MFA != nil && !idpMFA {
+		enabled, err := h.Auth.MFA.Enabled(ctx, uid)
+		if err != nil {
+			apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+			return
+		}
+		if enabled {
+			h.Auth.mfaChallenge(w, uid, device, "oidc")
+			return
+		}
+	}
+
+	meta := h.Auth.sessionMeta(r, device)
+	meta.AMR = "oidc"
+	if idpMFA {
+		meta.AMR += ",mfa"
+	}
+	h.Auth.issuePair(ctx, w, r, uid, meta)
+}
+
+// resolve finds the user for an identity: an existing link, else an account
+// with the same verified email (linked now, unless linkable refuses it), else
+// a new account when provisioning is on. how reports which of the three
+// happened.
+func (h OIDC) resolve(ctx context.Context, c oidc.Claims) (uid int64, how string, err error) {
+	iss := h.Provider.Issuer
+	uid, err = h.Identities.Find(ctx, iss, c.Subject)
+	if err == nil {
+		_ = h.Identities.Touch(ctx, iss, c.Subject, c.Email)
+		return uid, "existing", nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", apperr.E(500, "db_error", "db error", err, nil)
+	}
+	if c.Email == "" || !c.EmailVerified {
+		return 0, "", apperr.E(403, "oidc_email_unverified", "identity provider did not return a verified email", nil, nil)
+	}
+
+	how = "linked"
+	u, err := h.Auth.Users.FindByEmail(ctx, c.Email)
+	switch {
+	case err == nil:
+		if err := h.linkable(ctx, u.ID); err != nil {
+			return 0, "", err
+		}
+		uid = u.ID
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, "", apperr.E(500, "db_error", "db error", err, nil)
+	case !h.Auth.Cfg.OIDCProvision:
+		return 0, "", apperr.E(403, "oidc_no_account", "no account for this identity", nil, nil)
+	default:
+		how = "provisioned"
+		// No usable password: an empty hash never verifies.
+		if uid, err = h.Auth.Users.Create(ctx, c.Email, ""); err != nil {
+			return 0, "", apperr.E(500, "db_error", "db error", err, nil)
+		}
+		if h.Auth.Roles != nil {
+			_ = h.Auth.Roles.Assign(ctx, uid, "user")
+		}
+	}
+	_ = h.Auth.Users.MarkVerified(ctx, uid, c.Email)
+	if err := h.Identities.Link(ctx, uid, iss, c.Subject, c.Email); err != nil {
+		return 0, "", apperr.E(500, "db_error", "db error", err, nil)
+	}
+	return uid, how, nil
+}
+
+// linkable refuses to attach an identity by email alone to an account whose
+// takeover would matter most: one protected by MFA or holding any permission
+// beyond its own notes. Such accounts must be linked by an operator.
+func (h OIDC) linkable(ctx context.Context, uid int64) error {
+	refused := apperr.E(403, "oidc_link_refused", "this account cannot be linked automatically", nil, nil)
+	if h.Auth.MFA != nil {
+		enabled, err := h.Auth.MFA.Enabled(ctx, uid)
+		if err != nil {
+			return apperr.E(500, "db_error", "db error", err, nil)
+		}
+		if enabled {
+			return refused
+		}
+	}
+	if h.Auth.Roles != nil {
+		perms, err := h.Auth.Roles.Permissions(ctx, uid)
+		if err != nil {
+			return apperr.E(500, "db_error", "db error", err, nil)
+		}
+		for _, p := range perms {
+			if !strings.HasPrefix(p, "notes:") {
+				return refused
+			}
+		}
+	}
+	return nil
+}
+
+// syncRoles applies OIDC_ROLE_MAP: every role named in the map is granted
+// when one of its groups is present and removed otherwise. Other roles are
+// left alone.
+func (h OIDC) syncRoles(ctx context.Context, uid int64, groups []string) error {
+	if h.Auth.Roles == nil {
+		return nil
+	}
+	want := map[string]bool{}
+	for group, role := range h.Auth.Cfg.OIDCRoleMap {
+		want[role] = want[role] || slices.Contains(groups, group)
+	}
+	roles := make([]string, 0, len(want))
+	for role := range want {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	for _, role := range roles {
+		var err error
+		if want[role] {
+			err = h.Auth.Roles.Assign(ctx, uid, role)
+		} else {
+			err = h.Auth.Roles.Unassign(ctx, uid, role)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
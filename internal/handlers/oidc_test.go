@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/oidc"
+	"github.com/Veysel440/go-notes-api/internal/oidc/oidctest"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDC_LinksVerifiedEmailAndSyncsRoles(t *testing.T) {
+	withTestKeys(t)
+	idp := oidctest.New("notes", "s3cret")
+	defer idp.Close()
+	idp.Claims = jwt.MapClaims{"sub": "u-1", "email": "a@corp.example", "email_verified": true, "groups": []string{"staff"}}
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{
+		JWTIssuer: "iss", JWTAudience: "aud", JWTTTL: time.Minute, RefreshTTL: time.Hour, DBTimeout: time.Second,
+		OIDCRoleMap: map[string]string{"admins": "admin", "staff": "user"},
+	}
+	h := OIDC{
+		Auth:       Auth{Cfg: cfg, Users: &repos.Users{DB: db}, Roles: &repos.Roles{DB: db}, Tokens: &repos.RefreshTokens{DB: db}},
+		Provider:   &oidc.Provider{Issuer: idp.URL, ClientID: "notes", ClientSecret: "s3cret", RedirectURL: "https://api.example/auth/oidc/callback"},
+		Identities: &repos.Identities{DB: db},
+	}
+
+	req := oidcCallback(t, h)
+	callback := req.URL.String()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).WithArgs(idp.URL, "u-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("from users where email=?")).WithArgs("a@corp.example").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}).AddRow(int64(5), "a@corp.example", "x", false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT p.name FROM user_roles")).WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("notes:read").AddRow("notes:write"))
+	mock.ExpectExec(regexp.QuoteMeta("update users set email_verified_at=now()")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities")).WithArgs(int64(5), idp.URL, "u-1", "a@corp.example").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM roles WHERE name=?")).WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(2)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_roles")).WithArgs(int64(5), int64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM roles WHERE name=?")).WithArgs("user").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO user_roles")).WithArgs(int64(5), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	h.Callback(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"refresh"`) {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET", callback, nil)
+	rec = httptest.NewRecorder()
+	h.Callback(rec, req)
+	if rec.Code != 400 {
+		t.Fatalf("callback without flow cookie: %d", rec.Code)
+	}
+}
+
+// oidcCallback runs Login against the fake IdP and returns the callback
+// request it redirects back to, flow cookie attached.
+func oidcCallback(t *testing.T, h OIDC) *http.Request {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Login(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound || len(rec.Result().Cookies()) != 1 {
+		t.Fatalf("login: %d", rec.Code)
+	}
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noFollow.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	req := httptest.NewRequest("GET", strings.TrimPrefix(res.Header.Get("Location"), "https://api.example"), nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	return req
+}
+
+func testOIDC(db *sql.DB, idp *oidctest.IdP, cfg config.Config) OIDC {
+	cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTTTL, cfg.RefreshTTL, cfg.DBTimeout = "iss", "aud", time.Minute, time.Hour, time.Second
+	return OIDC{
+		Auth: Auth{Cfg: cfg, Users: &repos.Users{DB: db}, Roles: &repos.Roles{DB: db}, Tokens: &repos.RefreshTokens{DB: db},
+			MFA: &repos.MFA{DB: db}},
+		Provider:   &oidc.Provider{Issuer: idp.URL, ClientID: "notes", ClientSecret: "s3cret", RedirectURL: "https://api.example/auth/oidc/callback"},
+		Identities: &repos.Identities{DB: db},
+	}
+}
+
+func TestOIDC_RefusesEmailLinkToPrivilegedAccount(t *testing.T) {
+	withTestKeys(t)
+	idp := oidctest.New("notes", "s3cret")
+	defer idp.Close()
+	idp.Claims = jwt.MapClaims{"sub": "u-1", "email": "root@corp.example", "email_verified": true}
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	h := testOIDC(db, idp, config.Config{})
+	req := oidcCallback(t, h)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("from users where email=?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}).AddRow(int64(1), "root@corp.example", "x", true))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_mfa")).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"secret", "confirmed", "last_step"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT p.name FROM user_roles")).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("notes:read").AddRow("roles:write"))
+
+	rec := httptest.NewRecorder()
+	h.Callback(rec, req)
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), "oidc_link_refused") {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDC_LocalMFAStillChallenged(t *testing.T) {
+	withTestKeys(t)
+	idp := oidctest.New("notes", "s3cret")
+	defer idp.Close()
+	idp.Claims = jwt.MapClaims{"sub": "u-1", "email": "a@corp.example", "email_verified": true, "amr": []string{"mfa"}}
+
+	for _, trust := range []bool{false, true} {
+		db, mock, _ := sqlmock.New()
+		h := testOIDC(db, idp, config.Config{OIDCTrustAMR: trust})
+		req := oidcCallback(t, h)
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(int64(5)))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE user_identities")).WillReturnResult(sqlmock.NewResult(0, 1))
+		if trust {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).WillReturnResult(sqlmock.NewResult(1, 1))
+		} else {
+			mock.ExpectQuery(regexp.QuoteMeta("FROM user_mfa")).WithArgs(int64(5)).
+				WillReturnRows(sqlmock.NewRows([]string{"secret", "confirmed", "last_step"}).AddRow("S", true, int64(0)))
+		}
+
+		rec := httptest.NewRecorder()
+		h.Callback(rec, req)
+		want := `"mfa_token"`
+		if trust {
+			want = `"refresh"`
+		}
+		if rec.Code != 200 || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("trust=%v: %d %s", trust, rec.Code, rec.Body)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("trust=%v: %v", trust, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/mail"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/password"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/Veysel440/go-notes-api/internal/security"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Password struct {
+	Cfg          config.Config
+	Users        *repos.Users
+	Resets       *repos.PasswordResets
+	Tokens       *repos.RefreshTokens
+	Versions     *repos.TokenVersions
+	PATs         *repos.PATs
+	JTIStore     jtiRevoker
+	Mailer       mail.Mailer
+	Audit        *repos.Audit
+	EmailLimiter func(string) bool
+	BruteRedis   *redis.Client
+}
+
+func resetLink(cfg config.Config, raw string) string {
+	return strings.TrimRight(cfg.AppBaseURL, "/") + "/reset-password?token=" + url.QueryEscape(raw)
+}
+
+// Forgot always answers 202 so it cannot be used to probe for accounts.
+func (h Password) Forgot(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Email == "" {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	email := normEmail(in.Email)
+	if h.EmailLimiter != nil && !h.EmailLimiter(email) {
+		apperr.Write(w, r, apperr.TooMany)
+		return
+	}
+	if h.BruteRedis != nil {
+		br := security.Brute{RDB: h.BruteRedis, Limit: 10, Window: 5 * time.Minute}
+		if ok, _, ttl := br.Allow(r.Context(), r, "forgot:"+email); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())))
+			apperr.Write(w, r, apperr.TooMany)
+			return
+		}
+	}
+
+	go h.sendReset(context.WithoutCancel(r.Context()), email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendReset looks up email and mails it a reset token. It runs after Forgot
+// has answered, so the response time does not depend on the account existing.
+func (h Password) sendReset(ctx context.Context, email string) {
+	dctx, cancel := context.WithTimeout(ctx, h.Cfg.DBTimeout)
+	defer cancel()
+
+	u, err := h.Users.FindByEmail(dctx, email)
+	if err != nil {
+		return
+	}
+	raw, err := h.Resets.Create(dctx, u.ID, h.Cfg.PasswordResetTTL)
+	if err != nil || h.Mailer == nil {
+		return
+	}
+	mctx, mcancel := context.WithTimeout(ctx, h.Cfg.MailTimeout)
+	defer mcancel()
+	_ = h.Mailer.Send(mctx, mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Open the link below or send the token to POST /auth/password/reset to choose a new password.\n\n%s\n\nToken: %s\n\nThe link expires in %s. If you did not ask for this, ignore this email.",
+			resetLink(h.Cfg, raw), raw, h.Cfg.PasswordResetTTL),
+	})
+}
+
+// errSessionsKept reports a password that was stored while ending the old
+// sessions failed: the client must not assume they are gone.
+func errSessionsKept(err error) error {
+	return apperr.E(500, "logout_failed", "password changed but existing sessions were not ended; call POST /me/logout-all", err, nil)
+}
+
+func (h Password) Reset(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Token == "" {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	var email string
+	if uid, err := h.Resets.Peek(ctx, in.Token); err == nil {
+		if u, err := h.Users.FindByID(ctx, uid); err == nil {
+			email = u.Email
+		}
+	}
+	if msg := password.PolicyFromConfig(h.Cfg).Check(in.Password, email); msg != "" {
+		apperr.Write(w, r, apperr.Validation(map[string]string{"password": msg}))
+		return
+	}
+	hash, err := password.FromConfig(h.Cfg).Hash(in.Password)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "hash_failed", "server error", err, nil))
+		return
+	}
+
+	uid, err := h.Resets.Consume(ctx, in.Token, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.E(400, "invalid_token", "invalid or expired token", nil, nil))
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, uid); err != nil {
+		recordEvent(h.Audit, r, uid, "password_reset", http.StatusInternalServerError, nil)
+		apperr.Write(w, r, errSessionsKept(err))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "password_reset", http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Password) Change(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Current string `json:"current_password"`
+		New     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	hasher := password.FromConfig(h.Cfg)
+	u, err := h.Users.FindByID(ctx, uid)
+	var ok bool
+	if err == nil {
+		ok, _, _ = hasher.Verify(u.PasswordHash, in.Current)
+	}
+	if !ok {
+		time.Sleep(250 * time.Millisecond)
+		apperr.Write(w, r, apperr.Validation(map[string]string{"current_password": "incorrect"}))
+		return
+	}
+	if msg := password.PolicyFromConfig(h.Cfg).Check(in.New, u.Email); msg != "" {
+		apperr.Write(w, r, apperr.Validation(map[string]string{"new_password": msg}))
+		return
+	}
+	hash, err := hasher.Hash(in.New)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "hash_failed", "server error", err, nil))
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, uid, hash); err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	if err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, uid); err != nil {
+		recordEvent(h.Audit, r, uid, "password_change", http.StatusInternalServerError, nil)
+		apperr.Write(w, r, errSessionsKept(err))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "password_change", http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/password"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPassword_ForgotAnswersBeforeLookup(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("select id,email,password_hash")).
+		WithArgs("a@example.com").
+		WillDelayFor(200 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}))
+
+	var keys []string
+	h := Password{
+		Cfg:          config.Config{DBTimeout: time.Second},
+		Users:        &repos.Users{DB: db},
+		EmailLimiter: func(k string) bool { keys = append(keys, k); return true },
+	}
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	h.Forgot(rr, httptest.NewRequest("POST", "/auth/password/forgot", strings.NewReader(`{"email":"  A@Example.com "}`)))
+	if rr.Code != http.StatusAccepted || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("code=%d after %s", rr.Code, time.Since(start))
+	}
+	if len(keys) != 1 || keys[0] != "a@example.com" {
+		t.Fatalf("limiter keys: %v", keys)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("lookup never ran: %v", err)
+	}
+}
+
+func TestPassword_ChangeEndsEverySession(t *testing.T) {
+	withTestKeys(t)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", DBTimeout: time.Second, PasswordHash: "bcrypt", BcryptCost: bcrypt.MinCost, PasswordMinLength: 8}
+	access, _ := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+	old, _ := password.FromConfig(cfg).Hash("old secret")
+
+	mock.ExpectQuery(regexp.QuoteMeta("from users where id=?")).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}).AddRow(int64(7), "a@example.com", old, true))
+	mock.ExpectExec(regexp.QuoteMeta("update users set password_hash=?")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=token_version+1")).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT token_version, status")).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version", "status", "suspended_until", "status_reason"}).AddRow(4, "active", nil, ""))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens")).WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE user_id=?")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM personal_access_tokens WHERE user_id=?")).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	h := Password{
+		Cfg:      cfg,
+		Users:    &repos.Users{DB: db},
+		Tokens:   &repos.RefreshTokens{DB: db},
+		Versions: &repos.TokenVersions{DB: db},
+		PATs:     &repos.PATs{DB: db},
+	}
+	req := httptest.NewRequest("POST", "/me/password", strings.NewReader(`{"current_password":"old secret","new_password":"plum-Tractor-91-quietly"}`))
+	req.Header.Set("Authorization", "Bearer "+access)
+	rr := httptest.NewRecorder()
+	middleware.AuthWith(cfg, middleware.AuthDeps{})(http.HandlerFunc(h.Change)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("change: %d %s", rr.Code, rr.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPassword_ChangeFailsWhenSessionsSurvive(t *testing.T) {
+	withTestKeys(t)
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud", DBTimeout: time.Second, PasswordHash: "bcrypt", BcryptCost: bcrypt.MinCost, PasswordMinLength: 8}
+	access, _ := Auth{Cfg: cfg}.signAccess(context.Background(), 7, repos.SessionMeta{AccessJTI: "j", AccessExp: time.Now().Add(time.Minute)})
+	old, _ := password.FromConfig(cfg).Hash("old secret")
+
+	mock.ExpectQuery(regexp.QuoteMeta("from users where id=?")).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "verified"}).AddRow(int64(7), "a@example.com", old, true))
+	mock.ExpectExec(regexp.QuoteMeta("update users set password_hash=?")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=token_version+1")).WithArgs(int64(7)).
+		WillReturnError(errors.New("deadlock"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens")).WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE user_id=?")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM personal_access_tokens WHERE user_id=?")).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	h := Password{
+		Cfg:      cfg,
+		Users:    &repos.Users{DB: db},
+		Tokens:   &repos.RefreshTokens{DB: db},
+		Versions: &repos.TokenVersions{DB: db},
+		PATs:     &repos.PATs{DB: db},
+	}
+	req := httptest.NewRequest("POST", "/me/password", strings.NewReader(`{"current_password":"old secret","new_password":"plum-Tractor-91-quietly"}`))
+	req.Header.Set("Authorization", "Bearer "+access)
+	rr := httptest.NewRecorder()
+	middleware.AuthWith(cfg, middleware.AuthDeps{})(http.HandlerFunc(h.Change)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "logout_failed") {
+		t.Fatalf("change: %d %s", rr.Code, rr.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
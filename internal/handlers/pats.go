@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PATs struct {
+	Cfg   config.Config
+	Repo  *repos.PATs
+	Audit *repos.Audit
+}
+
+func (h PATs) Routes(r chi.Router) {
+	r.Get("/tokens", h.List)
+	r.Post("/tokens", h.Create)
+	r.Delete("/tokens/{id}", h.Delete)
+}
+
+func (h PATs) List(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	items, err := h.Repo.List(ctx, uid)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func (h PATs) Create(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	var in struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	fields := map[string]string{}
+	if in.Name == "" || len(in.Name) > 100 {
+		fields["name"] = "required, max 100 characters"
+	}
+	if len(in.Scopes) == 0 {
+		fields["scopes"] = "at least one of " + strings.Join(repos.Scopes, ", ")
+	}
+	for _, s := range in.Scopes {
+		switch {
+		case !slices.Contains(repos.Scopes, s):
+			fields["scopes"] = "unknown scope " + s
+		case s == "admin" && h.Cfg.AdminRequireMFA:
+			// A PAT never carries MFA, so it could not reach /admin anyway.
+			fields["scopes"] = "admin scope is unavailable while ADMIN_REQUIRE_MFA is on"
+		}
+	}
+	if in.ExpiresInDays < 0 || in.ExpiresInDays > 366 {
+		fields["expires_in_days"] = "0 (never) to 366"
+	}
+	if len(fields) > 0 {
+		apperr.Write(w, r, apperr.Validation(fields))
+		return
+	}
+	slices.Sort(in.Scopes)
+	in.Scopes = slices.Compact(in.Scopes)
+	var exp *time.Time
+	if in.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(in.ExpiresInDays) * 24 * time.Hour)
+		exp = &t
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	raw, pat, err := h.Repo.Create(ctx, uid, in.Name, in.Scopes, exp)
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "pat_created", http.StatusCreated, map[string]any{"id": pat.ID, "scopes": pat.Scopes})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{"token": raw, "item": pat})
+}
+
+func (h PATs) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if err := h.Repo.Delete(ctx, uid, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			apperr.Write(w, r, apperr.NotFound)
+			return
+		}
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "pat_revoked", http.StatusNoContent, map[string]any{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Sessions struct {
+	Cfg      config.Config
+	Tokens   *repos.RefreshTokens
+	JTIStore jtiRevoker
+	Versions *repos.TokenVersions
+	PATs     *repos.PATs
+	Audit    *repos.Audit
+}
+
+func (h Sessions) Routes(r chi.Router) {
+	r.Get("/sessions", h.List)
+	r.Delete("/sessions/{id}", h.Delete)
+	r.Post("/sessions/revoke-others", h.RevokeOthers)
+	r.Post("/logout-all", h.LogoutAll)
+}
+
+func (h Sessions) List(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	ss, err := h.Tokens.Sessions(ctx, uid, middleware.TokenID(r.Context()))
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": ss})
+}
+
+func (h Sessions) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	s, err := h.Tokens.RevokeSession(ctx, uid, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apperr.Write(w, r, apperr.NotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	revokeAccess(r.Context(), h.JTIStore, s)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Sessions) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	ss, err := h.Tokens.RevokeOthers(ctx, uid, middleware.TokenID(r.Context()))
+	if err != nil {
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	for _, s := range ss {
+		revokeAccess(r.Context(), h.JTIStore, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"revoked": len(ss)})
+}
+
+// LogoutAll ends every session of the caller, the current one included.
+func (h Sessions) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	uid, _ := middleware.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if err := logoutAll(ctx, h.Versions, h.Tokens, h.PATs, h.JTIStore, uid); err != nil {
+		apperr.Write(w, r, apperr.E(500, "server_error", "logout failed, retry", err, nil))
+		return
+	}
+	recordEvent(h.Audit, r, uid, "logout_all", http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/mail"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+// Verify handles email verification. Tokens are random values stored only as
+// hashes, so they outlive signing key rotation and are single use.
+type Verify struct {
+	Cfg     config.Config
+	Users   *repos.Users
+	Tokens  *repos.EmailVerifications
+	Mailer  mail.Mailer
+	Limiter func(string) bool
+}
+
+var errBadVerifyToken = apperr.E(400, "invalid_token", "invalid or expired token", nil, nil)
+
+// Send mails a verification link for uid/email.
+func (h Verify) Send(ctx context.Context, uid int64, email string) error {
+	if h.Mailer == nil || h.Tokens == nil {
+		return nil
+	}
+	tok, err := h.Tokens.Create(ctx, uid, email, h.Cfg.VerifyTTL)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimRight(h.Cfg.AppBaseURL, "/") + "/verify-email?token=" + url.QueryEscape(tok)
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm your address by opening the link below or by sending the token to POST /auth/verify.\n\n%s\n\nToken: %s\n\nThe link expires in %s.",
+			link, tok, h.Cfg.VerifyTTL),
+	})
+}
+
+// sendLater runs Send in the background, detached from the request but
+// bounded by DBTimeout plus MailTimeout, so a slow mail server neither delays
+// the response nor keeps the goroutine alive.
+func (h Verify) sendLater(ctx context.Context, uid int64, email string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.Cfg.DBTimeout+h.Cfg.MailTimeout)
+		defer cancel()
+		_ = h.Send(ctx, uid, email)
+	}()
+}
+
+func (h Verify) Confirm(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Token == "" {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Cfg.DBTimeout)
+	defer cancel()
+
+	if _, err := h.Tokens.Consume(ctx, in.Token); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			apperr.Write(w, r, errBadVerifyToken)
+			return
+		}
+		apperr.Write(w, r, apperr.E(500, "db_error", "db error", err, nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"verified": true})
+}
+
+// Resend always answers 202 so it cannot be used to probe for accounts.
+func (h Verify) Resend(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Email == "" {
+		apperr.Write(w, r, apperr.BadRequest)
+		return
+	}
+	email := normEmail(in.Email)
+	if h.Limiter != nil && !h.Limiter(email) {
+		apperr.Write(w, r, apperr.TooMany)
+		return
+	}
+
+	go h.resend(context.WithoutCancel(r.Context()), email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resend looks up email and mails a new link if it is unverified. Like
+// Password.sendReset it runs after Resend has answered, so the response time
+// does not depend on the account existing.
+func (h Verify) resend(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, h.Cfg.DBTimeout+h.Cfg.MailTimeout)
+	defer cancel()
+
+	dctx, dcancel := context.WithTimeout(ctx, h.Cfg.DBTimeout)
+	u, err := h.Users.FindByEmail(dctx, email)
+	dcancel()
+	if err == nil && !u.Verified {
+		_ = h.Send(ctx, u.ID, u.Email)
+	}
+}
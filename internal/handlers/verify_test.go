@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/mail"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+type fakeMailer struct{ sent []mail.Message }
+
+func (f *fakeMailer) Send(_ context.Context, m mail.Message) error {
+	f.sent = append(f.sent, m)
+	return nil
+}
+
+func withTestKeys(t *testing.T) {
+	t.Helper()
+	old := jwtauth.Provider()
+	t.Cleanup(func() { jwtauth.SetProvider(old) })
+	jwtauth.SetProvider(jwtauth.EnvProvider{Current: "k1", Set: map[string]jwtauth.Key{
+		"k1": {KID: "k1", Alg: jwtauth.HS256, Secret: []byte("secret")},
+	}})
+}
+
+func TestVerify_SendMailsStoredToken(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO email_verifications(user_id,email,token_hash,expires_at)")).
+		WithArgs(int64(7), "a@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	cfg := config.Config{VerifyTTL: time.Hour, AppBaseURL: "https://app/"}
+	m := &fakeMailer{}
+	v := Verify{Cfg: cfg, Tokens: &repos.EmailVerifications{DB: db}, Mailer: m}
+
+	if err := v.Send(context.Background(), 7, "a@example.com"); err != nil || len(m.sent) != 1 {
+		t.Fatalf("send: %v %d", err, len(m.sent))
+	}
+	body := m.sent[0].Body
+	i := strings.Index(body, "Token: ")
+	if i < 0 || !strings.Contains(body, "https://app/verify-email?token=") {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	tok := strings.Fields(body[i+len("Token: "):])[0]
+	if len(tok) != 64 {
+		t.Fatalf("unexpected token %q", tok)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+)
+
+type WellKnown struct{}
+
+func (WellKnown) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwtauth.JWKSMaxAge.Seconds())))
+	_ = json.NewEncoder(w).Encode(jwtauth.JWKS(jwtauth.Provider()))
+}
@@ -0,0 +1,106 @@
+package jti
+
+import (
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+)
+
+type entry struct {
+	revoked bool
+	exp     time.Time
+}
+
+// Checker answers revocation lookups from a short-lived local cache. Revocations
+// made anywhere in the cluster are pushed to it through Store's pub/sub channel,
+// so negative entries only go stale if a message is lost.
+type Checker struct {
+	Store  Store
+	TTL    time.Duration
+	MaxAge time.Duration
+
+	mu sync.RWMutex
+	m  map[string]entry
+}
+
+func (c *Checker) get(jti string) (entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.m[jti]
+	if !ok || time.Now().After(e.exp) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// put records a lookup result. A revocation is final: a lookup that read
+// "not revoked" before a pushed revocation arrived must not overwrite it.
+func (c *Checker) put(jti string, revoked bool) {
+	ttl := c.TTL
+	if revoked {
+		ttl = c.MaxAge
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]entry{}
+	}
+	if e, ok := c.m[jti]; ok && e.revoked && !revoked && now.Before(e.exp) {
+		return
+	}
+	c.m[jti] = entry{revoked: revoked, exp: now.Add(ttl)}
+}
+
+func (c *Checker) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if e, ok := c.get(jti); ok {
+		return e.revoked, nil
+	}
+	revoked, err := c.Store.IsRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+	c.put(jti, revoked)
+	if e, ok := c.get(jti); ok {
+		return e.revoked, nil
+	}
+	return revoked, nil
+}
+
+func (c *Checker) Listen(ctx context.Context) {
+	sub := c.Store.RDB.Subscribe(ctx, c.Store.channel())
+	defer sub.Close()
+
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+	c.listen(ctx, sub.Channel(), sweep.C)
+}
+
+func (c *Checker) listen(ctx context.Context, ch <-chan *redis.Message, sweep <-chan time.Time) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			c.put(msg.Payload, true)
+		case <-sweep:
+			c.sweep()
+		}
+	}
+}
+
+func (c *Checker) sweep() {
+	now := time.Now()
+	c.mu.Lock()
+	for k, e := range c.m {
+		if now.After(e.exp) {
+			delete(c.m, k)
+		}
+	}
+	c.mu.Unlock()
+}
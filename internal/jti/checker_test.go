@@ -0,0 +1,85 @@
+package jti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+)
+
+func TestChecker_CachedRevocationIsNeverDowngraded(t *testing.T) {
+	c := &Checker{TTL: time.Second, MaxAge: time.Hour}
+	c.put("j1", true)
+	// A lookup that read the store before the revocation landed.
+	c.put("j1", false)
+	if revoked, err := c.IsRevoked(context.Background(), "j1"); err != nil || !revoked {
+		t.Fatalf("downgraded: %v %v", revoked, err)
+	}
+
+	c.put("j2", false)
+	c.put("j2", true)
+	if e, ok := c.get("j2"); !ok || !e.revoked {
+		t.Fatalf("upgrade lost: %+v", e)
+	}
+}
+
+func TestChecker_SweepDropsExpired(t *testing.T) {
+	c := &Checker{TTL: time.Millisecond, MaxAge: time.Hour}
+	c.put("live", false)
+	c.put("gone", true)
+	c.m["gone"] = entry{revoked: true, exp: time.Now().Add(-time.Second)}
+	time.Sleep(5 * time.Millisecond)
+
+	c.sweep()
+	if len(c.m) != 0 {
+		t.Fatalf("left after sweep: %v", c.m)
+	}
+	// An expired revocation may be replaced again.
+	c.put("gone", false)
+	if e, ok := c.get("gone"); !ok || e.revoked {
+		t.Fatalf("expired entry kept: %+v", e)
+	}
+}
+
+func TestChecker_ListenMarksRevoked(t *testing.T) {
+	c := &Checker{TTL: time.Minute, MaxAge: time.Hour}
+	c.put("j1", false)
+	c.m["old"] = entry{exp: time.Now().Add(-time.Second)}
+
+	ch := make(chan *redis.Message)
+	sweep := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		c.listen(context.Background(), ch, sweep)
+		close(done)
+	}()
+	ch <- &redis.Message{Payload: "j1"}
+	sweep <- time.Now()
+	close(ch)
+	<-done
+
+	if e, ok := c.get("j1"); !ok || !e.revoked {
+		t.Fatalf("push not applied: %+v", e)
+	}
+	if _, ok := c.m["old"]; ok {
+		t.Fatal("sweep tick ignored")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.listen(ctx, make(chan *redis.Message), nil)
+}
+
+func TestChecker_StoreErrorIsNotCached(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer rdb.Close()
+	c := &Checker{Store: Store{RDB: rdb, Prefix: "jti:"}, TTL: time.Minute, MaxAge: time.Hour}
+
+	if _, err := c.IsRevoked(context.Background(), "j1"); err == nil {
+		t.Fatal("want store error")
+	}
+	if _, ok := c.get("j1"); ok {
+		t.Fatal("error cached")
+	}
+}
@@ -21,5 +21,10 @@ func (s Store) IsRevoked(ctx context.Context, jti string) (bool, error) {
 }
 
 func (s Store) Revoke(ctx context.Context, jti string, ttl time.Duration) error {
-	return s.RDB.SetEx(ctx, s.Prefix+jti, "1", ttl).Err()
+	if err := s.RDB.SetEx(ctx, s.Prefix+jti, "1", ttl).Err(); err != nil {
+		return err
+	}
+	return s.RDB.Publish(ctx, s.channel(), jti).Err()
 }
+
+func (s Store) channel() string { return s.Prefix + "revoked" }
@@ -0,0 +1,323 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// JWKSMaxAge is how long verifiers may cache /.well-known/jwks.json.
+const JWKSMaxAge = 5 * time.Minute
+
+// DirProvider loads keys from a directory: <kid>.pem holds an asymmetric
+// private key, <kid>.key an HS256 secret and the file "current" names the
+// signing kid. A key added by Rotate is listed in "next" with the time it
+// takes over, so it is published Publish ahead of signing. Keys retired by
+// Rotate stay available for verification until Retain has passed, so tokens
+// signed with them can still expire naturally.
+type DirProvider struct {
+	Dir     string
+	Alg     string
+	Retain  time.Duration
+	Publish time.Duration
+
+	// rot serialises changes to the directory (Rotate, Reload and pruning)
+	// so concurrent rotations cannot interleave their writes.
+	rot     sync.Mutex
+	mu      sync.RWMutex
+	current string
+	next    string
+	nextAt  time.Time
+	set     map[string]Key
+	stamp   string
+}
+
+var ErrRotateUnsupported = errors.New("jwtauth: key provider does not support rotation")
+
+type Rotator interface {
+	Rotate() (string, error)
+}
+
+func NewDirProvider(dir, alg string, retain time.Duration) (*DirProvider, error) {
+	p := &DirProvider{Dir: dir, Alg: alg, Retain: retain}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	if p.CurrentKID() == "" {
+		if _, err := p.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// CurrentKID is the signing kid: the pending next key once its time has
+// come, the current one before.
+func (p *DirProvider) CurrentKID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.next != "" && !time.Now().Before(p.nextAt) {
+		return p.next
+	}
+	return p.current
+}
+
+func (p *DirProvider) Key(kid string) (Key, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	k, ok := p.set[kid]
+	return k, ok
+}
+
+func (p *DirProvider) Keys() []Key {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return sortedKeys(p.set)
+}
+
+func (p *DirProvider) Reload() error {
+	p.rot.Lock()
+	defer p.rot.Unlock()
+	return p.reload()
+}
+
+func (p *DirProvider) reload() error {
+	stamp, err := p.snapshot()
+	if err != nil {
+		return err
+	}
+	ents, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return err
+	}
+	set := map[string]Key{}
+	for _, e := range ents {
+		name := e.Name()
+		path := filepath.Join(p.Dir, name)
+		switch filepath.Ext(name) {
+		case ".pem":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			kid := strings.TrimSuffix(name, ".pem")
+			k, err := ParsePrivateKeyPEM(kid, data)
+			if err != nil {
+				return err
+			}
+			set[kid] = k
+		case ".key":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			kid := strings.TrimSuffix(name, ".key")
+			set[kid] = Key{KID: kid, Alg: HS256, Secret: []byte(strings.TrimSpace(string(data)))}
+		}
+	}
+	current := ""
+	if b, err := os.ReadFile(filepath.Join(p.Dir, "current")); err == nil {
+		current = strings.TrimSpace(string(b))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if current != "" {
+		if _, ok := set[current]; !ok {
+			return fmt.Errorf("jwtauth: current kid %q has no key file", current)
+		}
+	}
+	next, nextAt := "", time.Time{}
+	if b, err := os.ReadFile(filepath.Join(p.Dir, "next")); err == nil {
+		f := strings.Fields(string(b))
+		if len(f) != 2 {
+			return fmt.Errorf("jwtauth: malformed next file %q", string(b))
+		}
+		if nextAt, err = time.Parse(time.RFC3339, f[1]); err != nil {
+			return fmt.Errorf("jwtauth: next file: %w", err)
+		}
+		if _, ok := set[f[0]]; !ok {
+			return fmt.Errorf("jwtauth: next kid %q has no key file", f[0])
+		}
+		next = f[0]
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	p.mu.Lock()
+	p.set, p.current, p.next, p.nextAt, p.stamp = set, current, next, nextAt, stamp
+	p.mu.Unlock()
+	return nil
+}
+
+// Rotate generates a new signing key and prunes keys retired longer than
+// Retain ago. The new key is published at once but only signs after Publish,
+// when the current one is retired; the first key of an empty directory signs
+// immediately. A pending key that never signed is retired by the next
+// rotation. It only changes the local directory: with several replicas the
+// directory must be shared (or the new key copied to every replica) before
+// tokens signed with it verify everywhere.
+func (p *DirProvider) Rotate() (string, error) {
+	p.rot.Lock()
+	defer p.rot.Unlock()
+
+	now := time.Now()
+	p.mu.RLock()
+	cur, pending := p.current, p.next
+	if pending != "" && !now.Before(p.nextAt) {
+		// Already signing; its predecessor was retired when it took over.
+		cur, pending = pending, ""
+	}
+	p.mu.RUnlock()
+
+	alg := p.Alg
+	if k, ok := p.Key(cur); ok {
+		alg = k.Alg
+	}
+	kid := "k" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	k, err := Generate(kid, alg)
+	if err != nil {
+		return "", err
+	}
+	name, data := kid+".key", []byte(string(k.Secret)+"\n")
+	if alg != HS256 {
+		if data, err = MarshalPrivateKeyPEM(k); err != nil {
+			return "", err
+		}
+		name = kid + ".pem"
+	}
+	if err := writeAtomic(filepath.Join(p.Dir, name), data); err != nil {
+		return "", err
+	}
+	retire := func(kid string, at time.Time) error {
+		return writeAtomic(filepath.Join(p.Dir, kid+".retired"), []byte(at.UTC().Format(time.RFC3339)+"\n"))
+	}
+	if pending != "" {
+		if err := retire(pending, now); err != nil {
+			return "", err
+		}
+	}
+	if cur == "" || p.Publish <= 0 {
+		if cur != "" {
+			if err := retire(cur, now); err != nil {
+				return "", err
+			}
+		}
+		if err := writeAtomic(filepath.Join(p.Dir, "current"), []byte(kid+"\n")); err != nil {
+			return "", err
+		}
+		if err := os.Remove(filepath.Join(p.Dir, "next")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	} else {
+		at := now.Add(p.Publish)
+		// Retirement, and so the Retain window, starts when the new key
+		// takes over.
+		if err := writeAtomic(filepath.Join(p.Dir, "current"), []byte(cur+"\n")); err != nil {
+			return "", err
+		}
+		if err := retire(cur, at); err != nil {
+			return "", err
+		}
+		if err := writeAtomic(filepath.Join(p.Dir, "next"), []byte(kid+" "+at.UTC().Format(time.RFC3339)+"\n")); err != nil {
+			return "", err
+		}
+	}
+	p.prune()
+	return kid, p.reload()
+}
+
+func (p *DirProvider) prune() {
+	ents, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range ents {
+		if filepath.Ext(e.Name()) != ".retired" {
+			continue
+		}
+		path := filepath.Join(p.Dir, e.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+		if err != nil || time.Since(at) < p.Retain {
+			continue
+		}
+		kid := strings.TrimSuffix(e.Name(), ".retired")
+		_ = os.Remove(filepath.Join(p.Dir, kid+".pem"))
+		_ = os.Remove(filepath.Join(p.Dir, kid+".key"))
+		_ = os.Remove(path)
+	}
+}
+
+// Watch reloads the key set on SIGHUP or when the directory contents change.
+func (p *DirProvider) Watch(ctx context.Context, every time.Duration, log *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+
+	reload := func(why string) {
+		if err := p.Reload(); err != nil {
+			log.Error("jwt_keys_reload", slog.String("trigger", why), slog.String("err", err.Error()))
+			return
+		}
+		log.Info("jwt_keys_reload", slog.String("trigger", why), slog.String("current", p.CurrentKID()))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload("sighup")
+		case <-tick.C:
+			p.rot.Lock()
+			p.prune()
+			p.rot.Unlock()
+			stamp, err := p.snapshot()
+			p.mu.RLock()
+			changed := err == nil && stamp != p.stamp
+			p.mu.RUnlock()
+			if changed {
+				reload("fs")
+			}
+		}
+	}
+}
+
+func (p *DirProvider) snapshot() (string, error) {
+	ents, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(ents))
+	for _, e := range ents {
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", e.Name(), fi.Size(), fi.ModTime().UnixNano()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|"), nil
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,69 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func pad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	out := make([]byte, n)
+	copy(out[n-len(b):], b)
+	return out
+}
+
+func PublicJWK(k Key) (JWK, bool) {
+	j := JWK{Kid: k.KID, Alg: k.Alg, Use: "sig"}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		j.Kty, j.N, j.E = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		j.Kty, j.Crv = "EC", pub.Curve.Params().Name
+		j.X, j.Y = b64(pad(pub.X.Bytes(), size)), b64(pad(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		j.Kty, j.Crv, j.X = "OKP", "Ed25519", b64(pub)
+	default:
+		return JWK{}, false
+	}
+	return j, true
+}
+
+func JWKS(p KeyProvider) map[string]any {
+	keys := []JWK{}
+	for _, k := range p.Keys() {
+		if j, ok := PublicJWK(k); ok {
+			keys = append(keys, j)
+		}
+	}
+	return map[string]any{"keys": keys}
+}
+
+func sortedKeys(set map[string]Key) []Key {
+	out := make([]Key, 0, len(set))
+	for _, k := range set {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].KID < out[j].KID })
+	return out
+}
@@ -1,43 +1,140 @@
 package jwtauth
 
 import (
-	"os"
-	"strings"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
 )
 
-type Keys struct {
+type Key struct {
+	KID    string
+	Alg    string
+	Secret []byte
+	Signer crypto.Signer
+	Public crypto.PublicKey
+}
+
+func (k Key) Method() jwt.SigningMethod { return jwt.GetSigningMethod(k.Alg) }
+
+func (k Key) signKey() any {
+	if k.Alg == HS256 {
+		return k.Secret
+	}
+	return k.Signer
+}
+
+func (k Key) verifyKey() any {
+	if k.Alg == HS256 {
+		return k.Secret
+	}
+	return k.Public
+}
+
+type KeyProvider interface {
+	CurrentKID() string
+	Key(kid string) (Key, bool)
+	Keys() []Key
+}
+
+type EnvProvider struct {
 	Current string
-	Set     map[string][]byte
-}
-
-func Load() Keys {
-	cur := os.Getenv("JWT_CURRENT_KID")
-	raw := os.Getenv("JWT_KEYS")
-	kset := map[string][]byte{}
-	if raw != "" {
-		parts := strings.Split(raw, ",")
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p == "" || !strings.Contains(p, ":") {
-				continue
-			}
-			i := strings.IndexByte(p, ':')
-			kid := p[:i]
-			sec := p[i+1:]
-			kset[kid] = []byte(sec)
-		}
+	Set     map[string]Key
+}
+
+func (e EnvProvider) CurrentKID() string         { return e.Current }
+func (e EnvProvider) Key(kid string) (Key, bool) { k, ok := e.Set[kid]; return k, ok }
+func (e EnvProvider) Keys() []Key                { return sortedKeys(e.Set) }
+
+func ParsePrivateKeyPEM(kid string, data []byte) (Key, error) {
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return Key{}, errors.New("jwtauth: no PEM block")
+	}
+	var (
+		raw any
+		err error
+	)
+	switch blk.Type {
+	case "RSA PRIVATE KEY":
+		raw, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	case "EC PRIVATE KEY":
+		raw, err = x509.ParseECPrivateKey(blk.Bytes)
+	default:
+		raw, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
 	}
-	if len(kset) == 0 {
-		kset["default"] = []byte(os.Getenv("JWT_SECRET"))
-		if cur == "" {
-			cur = "default"
+	if err != nil {
+		return Key{}, err
+	}
+	return FromSigner(kid, raw)
+}
+
+func FromSigner(kid string, raw any) (Key, error) {
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return Key{}, fmt.Errorf("jwtauth: %s: RSA key must be at least 2048 bits", kid)
 		}
+		return Key{KID: kid, Alg: RS256, Signer: k, Public: k.Public()}, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("jwtauth: %s: only P-256 EC keys are supported", kid)
+		}
+		return Key{KID: kid, Alg: ES256, Signer: k, Public: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return Key{KID: kid, Alg: EdDSA, Signer: k, Public: k.Public()}, nil
+	default:
+		return Key{}, fmt.Errorf("jwtauth: %s: unsupported key type %T", kid, raw)
+	}
+}
+
+func MarshalPrivateKeyPEM(k Key) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Signer)
+	if err != nil {
+		return nil, err
 	}
-	if cur == "" {
-		for k := range kset {
-			cur = k
-			break
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func Generate(kid, alg string) (Key, error) {
+	var (
+		raw any
+		err error
+	)
+	switch alg {
+	case HS256:
+		var b [32]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return Key{}, err
 		}
+		return Key{KID: kid, Alg: HS256, Secret: []byte(hex.EncodeToString(b[:]))}, nil
+	case RS256:
+		raw, err = rsa.GenerateKey(rand.Reader, 3072)
+	case ES256:
+		raw, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, fmt.Errorf("jwtauth: unknown alg %q", alg)
+	}
+	if err != nil {
+		return Key{}, err
 	}
-	return Keys{Current: cur, Set: kset}
+	return FromSigner(kid, raw)
 }
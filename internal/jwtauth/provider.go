@@ -1,14 +1,118 @@
 package jwtauth
 
-type KeyProvider interface {
-	CurrentKID() string
-	SecretFor(kid string) ([]byte, bool)
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type box struct{ p KeyProvider }
+
+var provider atomic.Pointer[box]
+
+var ErrNoKey = errors.New("jwtauth: no signing key")
+
+func SetProvider(p KeyProvider) { provider.Store(&box{p}) }
+func CurrentKID() string        { return Provider().CurrentKID() }
+
+// Provider returns the provider installed with SetProvider, or an empty one
+// that holds no keys.
+func Provider() KeyProvider {
+	if b := provider.Load(); b != nil {
+		return b.p
+	}
+	return EnvProvider{}
+}
+
+func Rotate() (string, error) {
+	if r, ok := Provider().(Rotator); ok {
+		return r.Rotate()
+	}
+	return "", ErrRotateUnsupported
 }
 
-type EnvProvider struct {
-	Current string
-	Set     map[string][]byte
+func Sign(claims jwt.Claims) (string, error) {
+	p := Provider()
+	k, ok := p.Key(p.CurrentKID())
+	if !ok {
+		return "", ErrNoKey
+	}
+	t := jwt.NewWithClaims(k.Method(), claims)
+	t.Header["kid"] = k.KID
+	return t.SignedString(k.signKey())
 }
 
-func (e EnvProvider) CurrentKID() string                  { return e.Current }
-func (e EnvProvider) SecretFor(kid string) ([]byte, bool) { v, ok := e.Set[kid]; return v, ok }
+func Keyfunc(t *jwt.Token) (any, error) { return keyfunc(Provider(), t) }
+
+func keyfunc(p KeyProvider, t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		kid = p.CurrentKID()
+	}
+	k, ok := p.Key(kid)
+	if !ok {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	if t.Method.Alg() != k.Alg {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return k.verifyKey(), nil
+}
+
+// LoadFromEnv reads HS256 secrets from JWT_KEYS ("kid:secret,...") and
+// asymmetric PEM private keys from JWT_KEY_FILES ("kid:/path/key.pem,...").
+func LoadFromEnv() (EnvProvider, error) {
+	keys := strings.TrimSpace(os.Getenv("JWT_KEYS"))
+	files := strings.TrimSpace(os.Getenv("JWT_KEY_FILES"))
+	current := strings.TrimSpace(os.Getenv("JWT_CURRENT_KID"))
+	secret := os.Getenv("JWT_SECRET")
+
+	set := map[string]Key{}
+	for _, p := range splitPairs(keys) {
+		set[p[0]] = Key{KID: p[0], Alg: HS256, Secret: []byte(p[1])}
+	}
+	for _, p := range splitPairs(files) {
+		data, err := os.ReadFile(p[1])
+		if err != nil {
+			return EnvProvider{}, fmt.Errorf("JWT_KEY_FILES: %w", err)
+		}
+		k, err := ParsePrivateKeyPEM(p[0], data)
+		if err != nil {
+			return EnvProvider{}, fmt.Errorf("JWT_KEY_FILES: %w", err)
+		}
+		set[p[0]] = k
+	}
+	if len(set) == 0 && secret != "" { // fallback
+		if current == "" {
+			current = "key1"
+		}
+		set[current] = Key{KID: current, Alg: HS256, Secret: []byte(secret)}
+	}
+	if current == "" {
+		for _, k := range sortedKeys(set) {
+			current = k.KID
+			break
+		}
+	} else if _, ok := set[current]; !ok {
+		return EnvProvider{}, fmt.Errorf("JWT_CURRENT_KID: no key %q in JWT_KEYS or JWT_KEY_FILES", current)
+	}
+	return EnvProvider{Current: current, Set: set}, nil
+}
+
+func splitPairs(s string) [][2]string {
+	var out [][2]string
+	if s == "" {
+		return out
+	}
+	for _, p := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), ":", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			out = append(out, [2]string{kv[0], kv[1]})
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSign_AsymmetricRoundTrip(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ed, _ := FromSigner("ed1", edPriv)
+	ec, _ := FromSigner("ec1", ecPriv)
+
+	old := Provider()
+	defer SetProvider(old)
+
+	for _, cur := range []Key{ed, ec} {
+		SetProvider(EnvProvider{Current: cur.KID, Set: map[string]Key{"ed1": ed, "ec1": ec}})
+		raw, err := Sign(jwt.MapClaims{"sub": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := jwt.Parse(raw, Keyfunc)
+		if err != nil || !tok.Valid || tok.Header["kid"] != cur.KID || tok.Method.Alg() != cur.Alg {
+			t.Fatalf("%s: verify failed: %v", cur.KID, err)
+		}
+	}
+
+	jwks := JWKS(Provider())["keys"].([]JWK)
+	if len(jwks) != 2 || jwks[0].Kid != "ec1" || jwks[0].Crv != "P-256" || jwks[1].Kty != "OKP" {
+		t.Fatalf("unexpected jwks: %+v", jwks)
+	}
+}
+
+func TestKeyfunc_RejectsAlgorithmMismatch(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	ed, _ := FromSigner("k1", edPriv)
+	p := EnvProvider{Current: "k1", Set: map[string]Key{"k1": ed}}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": 1})
+	forged.Header["kid"] = "k1"
+	raw, _ := forged.SignedString([]byte(ed25519.PublicKey(ed.Public.(ed25519.PublicKey))))
+	if _, err := jwt.Parse(raw, func(t *jwt.Token) (any, error) { return keyfunc(p, t) }); err == nil {
+		t.Fatal("HS256 token must not verify against an EdDSA key")
+	}
+}
+
+func TestDirProvider_RotateKeepsOldKeysForVerification(t *testing.T) {
+	old := Provider()
+	defer SetProvider(old)
+
+	dir := t.TempDir()
+	p, err := NewDirProvider(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProvider(p)
+	first := p.CurrentKID()
+	raw, err := Sign(jwt.MapClaims{"sub": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Rotate()
+	if err != nil || second == first || p.CurrentKID() != second {
+		t.Fatalf("rotate: %v %q %q", err, first, second)
+	}
+	if _, err := jwt.Parse(raw, Keyfunc); err != nil {
+		t.Fatalf("token signed with retired key rejected: %v", err)
+	}
+
+	other, err := NewDirProvider(dir, HS256, time.Hour)
+	if err != nil || other.CurrentKID() != second || len(other.Keys()) != 2 {
+		t.Fatalf("reload from dir: %v %q %d", err, other.CurrentKID(), len(other.Keys()))
+	}
+
+	p.Retain = 0
+	p.prune()
+	if err := p.Reload(); err != nil || len(p.Keys()) != 1 {
+		t.Fatalf("prune: %v %d", err, len(p.Keys()))
+	}
+	if _, err := jwt.Parse(raw, Keyfunc); err == nil {
+		t.Fatal("expected pruned key to be rejected")
+	}
+}
+
+func TestLoadFromEnv_BadKeyFileIsAnError(t *testing.T) {
+	t.Setenv("JWT_KEY_FILES", "k1:/nonexistent/key.pem")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("want error for unreadable key file")
+	}
+}
+
+func TestLoadFromEnv_UnknownCurrentKIDIsAnError(t *testing.T) {
+	t.Setenv("JWT_KEYS", "k1:secret")
+	t.Setenv("JWT_CURRENT_KID", "k2")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("want error for a current kid without a key")
+	}
+	t.Setenv("JWT_CURRENT_KID", "k1")
+	if p, err := LoadFromEnv(); err != nil || p.Current != "k1" {
+		t.Fatalf("known kid: %+v %v", p, err)
+	}
+}
+
+func TestDirProvider_ConcurrentRotate(t *testing.T) {
+	p, err := NewDirProvider(t.TempDir(), HS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string, 8)
+	for range 8 {
+		go func() {
+			kid, err := p.Rotate()
+			if err != nil {
+				t.Error(err)
+			}
+			done <- kid
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+	if _, ok := p.Key(p.CurrentKID()); !ok || len(p.Keys()) != 9 {
+		t.Fatalf("current=%q keys=%d", p.CurrentKID(), len(p.Keys()))
+	}
+}
+
+func TestDirProvider_PublishesBeforeSigning(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewDirProvider(dir, HS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Publish = time.Hour
+	first := p.CurrentKID()
+
+	second, err := p.Rotate()
+	if err != nil || p.CurrentKID() != first || len(p.Keys()) != 2 {
+		t.Fatalf("rotate: %v current=%q", err, p.CurrentKID())
+	}
+	if _, ok := p.Key(second); !ok {
+		t.Fatal("pending key not published")
+	}
+
+	p.mu.Lock()
+	p.nextAt = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+	if p.CurrentKID() != second {
+		t.Fatalf("pending key not signing after its time: %q", p.CurrentKID())
+	}
+
+	third, err := p.Rotate()
+	if err != nil || p.CurrentKID() != second {
+		t.Fatalf("second rotate: %v current=%q", err, p.CurrentKID())
+	}
+	other, err := NewDirProvider(dir, HS256, time.Hour)
+	if err != nil || other.CurrentKID() != second || other.next != third {
+		t.Fatalf("reload from dir: %v %q %q", err, other.CurrentKID(), other.next)
+	}
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// New picks the mailer from MAIL_DRIVER: "smtp" delivers through SMTP_ADDR,
+// anything else writes to the local outbox. Outside dev the outbox needs
+// MAIL_OUTBOX_DIR.
+func New(cfg config.Config, log *slog.Logger) (Mailer, error) {
+	if cfg.MailDriver == "smtp" {
+		return SMTP{Addr: cfg.SMTPAddr, User: cfg.SMTPUser, Pass: cfg.SMTPPass, From: cfg.MailFrom, Timeout: cfg.MailTimeout}, nil
+	}
+	dev := cfg.Env == "dev"
+	if cfg.MailOutboxDir == "" && !dev {
+		return nil, fmt.Errorf("MAIL_DRIVER=outbox requires MAIL_OUTBOX_DIR when APP_ENV=%s: %w", cfg.Env, ErrNoOutboxDir)
+	}
+	return Outbox{Dir: cfg.MailOutboxDir, From: cfg.MailFrom, Dev: dev, Log: log}, nil
+}
+
+func render(from string, m Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(m.Body)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+)
+
+func TestOutbox_WritesEML(t *testing.T) {
+	dir := t.TempDir()
+	o := Outbox{Dir: dir, From: "no-reply@example.com"}
+	if err := o.Send(context.Background(), Message{To: "a@example.com", Subject: "Doğrula", Body: "token: x"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 || !strings.HasSuffix(files[0], "-a_at_example.com.eml") {
+		t.Fatalf("unexpected outbox: %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	s := string(b)
+	if !strings.Contains(s, "To: a@example.com\r\n") || !strings.Contains(s, "Subject: =?utf-8?q?") || !strings.HasSuffix(s, "token: x\r\n") {
+		t.Fatalf("unexpected message:\n%s", s)
+	}
+}
+
+func TestNew_OutboxNeedsDirOutsideDev(t *testing.T) {
+	if _, err := New(config.Config{Env: "prod", MailDriver: "outbox"}, nil); !errors.Is(err, ErrNoOutboxDir) {
+		t.Fatalf("want ErrNoOutboxDir, got %v", err)
+	}
+	if _, err := New(config.Config{Env: "dev", MailDriver: "outbox"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Outbox{}).Send(context.Background(), Message{To: "a@example.com"}); !errors.Is(err, ErrNoOutboxDir) {
+		t.Fatalf("send without dir outside dev: %v", err)
+	}
+}
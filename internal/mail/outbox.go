@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoOutboxDir is returned outside dev when the outbox has no directory:
+// messages carry live tokens and must not be dropped silently.
+var ErrNoOutboxDir = errors.New("mail: MAIL_OUTBOX_DIR is not set")
+
+// Outbox is the development mailer: messages are written as .eml files to Dir.
+// Without Dir only the recipient and subject are logged, and only in dev;
+// bodies are never logged because they contain tokens.
+type Outbox struct {
+	Dir  string
+	From string
+	Dev  bool
+	Log  *slog.Logger
+}
+
+func (o Outbox) Send(_ context.Context, m Message) error {
+	if o.Dir == "" {
+		if !o.Dev {
+			return ErrNoOutboxDir
+		}
+		if o.Log != nil {
+			o.Log.Info("mail_outbox", slog.String("to", m.To), slog.String("subject", m.Subject))
+		}
+		return nil
+	}
+	if err := os.MkdirAll(o.Dir, 0o700); err != nil {
+		return err
+	}
+	to := strings.NewReplacer("/", "_", "\\", "_", "@", "_at_").Replace(m.To)
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), to)
+	return os.WriteFile(filepath.Join(o.Dir, name), render(o.From, m), 0o600)
+}
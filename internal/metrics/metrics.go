@@ -14,6 +14,8 @@ type Registry struct {
 	HttpDur *prometheus.HistogramVec
 	DbDur   *prometheus.HistogramVec
 	DbErr   *prometheus.CounterVec
+	Cache   *prometheus.CounterVec
+	AuthRej *prometheus.CounterVec
 }
 
 func New() *Registry {
@@ -45,13 +47,29 @@ func New() *Registry {
 		[]string{"op"},
 	)
 
+	cache := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Cache lookups by cache and result (hit, miss, error)",
+		},
+		[]string{"cache", "result"},
+	)
+
+	authRej := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_rejected_total",
+			Help: "Rejected bearer tokens by reason",
+		},
+		[]string{"reason"},
+	)
+
 	r.MustRegister(
-		httpDur, dbDur, dbErr,
+		httpDur, dbDur, dbErr, cache, authRej,
 		prometheus.NewGoCollector(),
 		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 	)
 
-	return &Registry{reg: r, HttpDur: httpDur, DbDur: dbDur, DbErr: dbErr}
+	return &Registry{reg: r, HttpDur: httpDur, DbDur: dbDur, DbErr: dbErr, Cache: cache, AuthRej: authRej}
 }
 
 func (r *Registry) Handler() http.Handler {
@@ -74,6 +92,14 @@ func (r *Registry) ObserveDB(op string, d time.Duration) {
 	r.DbDur.WithLabelValues(op).Observe(d.Seconds())
 }
 
+func (r *Registry) ObserveCache(cache, result string) {
+	r.Cache.WithLabelValues(cache, result).Inc()
+}
+
+func (r *Registry) RejectAuth(reason string) {
+	r.AuthRej.WithLabelValues(reason).Inc()
+}
+
 type statusWrap struct {
 	http.ResponseWriter
 	status int
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"database/sql"
 	"net"
 	"net/http"
@@ -15,15 +16,32 @@ type sw struct {
 
 func (w *sw) WriteHeader(c int) { w.status = c; w.ResponseWriter.WriteHeader(c) }
 
+// identity is filled in by AuthWith further down the chain, so the row
+// written after the handler knows who made the request and on whose behalf.
+type identity struct{ uid, actor int64 }
+
+func noteIdentity(ctx context.Context, uid, actor int64) {
+	if id, ok := ctx.Value(identityKey).(*identity); ok {
+		id.uid, id.actor = uid, actor
+	}
+}
+
+func nullID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
 func (a Audit) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		wr := &sw{ResponseWriter: w, status: 200}
-		next.ServeHTTP(wr, r)
+		id := &identity{}
+		next.ServeHTTP(wr, r.WithContext(context.WithValue(r.Context(), identityKey, id)))
 		host, _, _ := net.SplitHostPort(r.RemoteAddr)
-		uid, _ := UserID(r.Context())
-		_, _ = a.DB.ExecContext(r.Context(),
-			`INSERT INTO audit_logs(user_id,method,path,status,ip,rid) VALUES(?,?,?,?,?,?)`,
-			uid, r.Method, r.URL.Path, wr.status, host, r.Header.Get("X-Request-ID"),
+		_, _ = a.DB.ExecContext(context.WithoutCancel(r.Context()),
+			`INSERT INTO audit_logs(user_id,actor_id,method,path,status,ip,rid) VALUES(?,?,?,?,?,?,?)`,
+			nullID(id.uid), nullID(id.actor), r.Method, r.URL.Path, wr.status, host, r.Header.Get("X-Request-ID"),
 		)
 	})
 }
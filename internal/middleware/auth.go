@@ -8,12 +8,22 @@ import (
 
 	"github.com/Veysel440/go-notes-api/internal/config"
 	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/metrics"
+	"github.com/Veysel440/go-notes-api/internal/repos"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type ctxKey string
 
-const userKey ctxKey = "uid"
+const (
+	userKey ctxKey = "uid"
+	jtiKey  ctxKey = "jti"
+	amrKey  ctxKey = "amr"
+	scpKey  ctxKey = "scope"
+	actKey  ctxKey = "act"
+
+	identityKey ctxKey = "identity"
+)
 
 func UserID(ctx context.Context) (int64, bool) {
 	v := ctx.Value(userKey)
@@ -21,45 +31,164 @@ func UserID(ctx context.Context) (int64, bool) {
 	return id, ok
 }
 
-func AuthWith(cfg config.Config) func(http.Handler) http.Handler {
-	keys := jwtauth.Load()
+func TokenID(ctx context.Context) string {
+	v, _ := ctx.Value(jtiKey).(string)
+	return v
+}
+
+// Actor returns the admin behind an impersonation token; ok is false for
+// every other credential.
+func Actor(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(actKey).(int64)
+	return id, ok
+}
+
+// AMR returns the authentication methods the access token was issued for.
+func AMR(ctx context.Context) []string {
+	v, _ := ctx.Value(amrKey).([]string)
+	return v
+}
+
+// Scopes returns the scopes of a restricted credential (personal access or
+// OAuth token). ok is false for full session tokens, which are unrestricted.
+func Scopes(ctx context.Context) (scopes []string, ok bool) {
+	scopes, ok = ctx.Value(scpKey).([]string)
+	return scopes, ok
+}
+
+type AuthDeps struct {
+	Revoked interface {
+		IsRevoked(ctx context.Context, jti string) (bool, error)
+	}
+	PATs interface {
+		Lookup(ctx context.Context, raw string) (repos.PAT, error)
+		Touch(ctx context.Context, id int64, ip string) error
+	}
+	Accounts interface {
+		State(ctx context.Context, uid int64) (repos.AccountState, error)
+	}
+	Mx *metrics.Registry
+}
+
+func AuthWith(cfg config.Config, deps AuthDeps) func(http.Handler) http.Handler {
 	expIss := cfg.JWTIssuer
 	expAud := cfg.JWTAudience
+	reject := func(w http.ResponseWriter, reason, msg string, code int) {
+		if deps.Mx != nil {
+			deps.Mx.RejectAuth(reason)
+		}
+		http.Error(w, msg, code)
+	}
+	// account rejects blocked users and, for JWTs, tokens older than the
+	// user's current version (tokens minted before versioning count as 0).
+	// Unless AccountFailOpen is set, an unknown state counts as blocked.
+	account := func(w http.ResponseWriter, r *http.Request, uid int64, ver float64, checkVer bool) bool {
+		if deps.Accounts == nil {
+			return true
+		}
+		st, err := deps.Accounts.State(r.Context(), uid)
+		switch {
+		case err != nil && !cfg.AccountFailOpen:
+			reject(w, "account_unavailable", "unavailable", 503)
+			return false
+		case err != nil:
+			return true
+		case st.Blocked(time.Now()):
+			reject(w, "account_blocked", "account "+st.Status, 403)
+			return false
+		case checkVer && int64(ver) < st.Version:
+			reject(w, "stale_version", "unauthorized", 401)
+			return false
+		}
+		return true
+	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			h := r.Header.Get("Authorization")
 			if !strings.HasPrefix(h, "Bearer ") {
-				http.Error(w, "unauthorized", 401)
+				reject(w, "missing", "unauthorized", 401)
 				return
 			}
 			tokStr := strings.TrimPrefix(h, "Bearer ")
-			tok, err := jwt.Parse(tokStr, func(t *jwt.Token) (interface{}, error) {
-				if kid, _ := t.Header["kid"].(string); kid != "" {
-					if k, ok := keys.Set[kid]; ok {
-						return k, nil
-					}
+			if strings.HasPrefix(tokStr, repos.PATPrefix) && deps.PATs != nil {
+				pat, err := deps.PATs.Lookup(r.Context(), tokStr)
+				if err != nil {
+					reject(w, "pat_invalid", "unauthorized", 401)
+					return
 				}
-				for _, k := range keys.Set {
-					return k, nil
+				if !account(w, r, pat.UserID, 0, false) {
+					return
 				}
-				return nil, jwt.ErrTokenMalformed
-			}, jwt.WithAudience(expAud), jwt.WithIssuer(expIss))
+				go func(ctx context.Context) { _ = deps.PATs.Touch(ctx, pat.ID, strings.TrimPrefix(IPKey(r), "ip:")) }(context.WithoutCancel(r.Context()))
+				noteIdentity(r.Context(), pat.UserID, 0)
+				ctx := context.WithValue(r.Context(), userKey, pat.UserID)
+				ctx = context.WithValue(ctx, amrKey, []string{"pat"})
+				ctx = context.WithValue(ctx, scpKey, pat.Scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			tok, err := jwt.Parse(tokStr, jwtauth.Keyfunc, jwt.WithAudience(expAud), jwt.WithIssuer(expIss))
 			if err != nil || !tok.Valid {
-				http.Error(w, "unauthorized", 401)
+				reject(w, "invalid", "unauthorized", 401)
 				return
 			}
 			claims, _ := tok.Claims.(jwt.MapClaims)
 			if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
-				http.Error(w, "expired", 401)
+				reject(w, "expired", "expired", 401)
 				return
 			}
 			idf, ok := claims["sub"].(float64)
 			if !ok {
-				http.Error(w, "unauthorized", 401)
+				reject(w, "invalid", "unauthorized", 401)
+				return
+			}
+			jti, _ := claims["jti"].(string)
+			if deps.Revoked != nil {
+				revoked, err := deps.Revoked.IsRevoked(r.Context(), jti)
+				switch {
+				case err != nil && !cfg.JTIFailOpen:
+					reject(w, "revocation_unavailable", "unavailable", 503)
+					return
+				case err == nil && revoked:
+					reject(w, "revoked", "unauthorized", 401)
+					return
+				}
+			}
+			ver, _ := claims["ver"].(float64)
+			if !account(w, r, int64(idf), ver, true) {
 				return
 			}
+			// An impersonation token lives only as long as the admin behind
+			// it: blocking them or logging them out everywhere ends it too.
+			var actor int64
+			if act, ok := claims["act"].(map[string]any); ok {
+				if sub, ok := act["sub"].(float64); ok && sub > 0 {
+					actor = int64(sub)
+					actVer, _ := act["ver"].(float64)
+					if !account(w, r, actor, actVer, true) {
+						return
+					}
+				}
+			}
 			ctx := context.WithValue(r.Context(), userKey, int64(idf))
+			ctx = context.WithValue(ctx, jtiKey, jti)
+			if actor > 0 {
+				ctx = context.WithValue(ctx, actKey, actor)
+			}
+			noteIdentity(ctx, int64(idf), actor)
+			if amr, ok := claims["amr"].([]any); ok {
+				methods := make([]string, 0, len(amr))
+				for _, m := range amr {
+					if s, ok := m.(string); ok {
+						methods = append(methods, s)
+					}
+				}
+				ctx = context.WithValue(ctx, amrKey, methods)
+			}
+			if scope, ok := claims["scope"].(string); ok {
+				ctx = context.WithValue(ctx, scpKey, strings.Fields(scope))
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type fakeRevoked struct {
+	revoked bool
+	err     error
+}
+
+func (f fakeRevoked) IsRevoked(context.Context, string) (bool, error) { return f.revoked, f.err }
+
+func testToken(t *testing.T, cfg config.Config, extra ...jwt.MapClaims) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"sub": 7, "iss": cfg.JWTIssuer, "aud": cfg.JWTAudience, "jti": "j1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for _, e := range extra {
+		for k, v := range e {
+			claims[k] = v
+		}
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tok.Header["kid"] = "k1"
+	raw, err := tok.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func withTestKeys(t *testing.T) {
+	t.Helper()
+	old := jwtauth.Provider()
+	t.Cleanup(func() { jwtauth.SetProvider(old) })
+	jwtauth.SetProvider(jwtauth.EnvProvider{Current: "k1", Set: map[string]jwtauth.Key{
+		"k1": {KID: "k1", Alg: jwtauth.HS256, Secret: []byte("secret")},
+	}})
+}
+
+func TestAuthWith_Revocation(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	raw := testToken(t, cfg)
+
+	cases := []struct {
+		name     string
+		chk      fakeRevoked
+		failOpen bool
+		want     int
+	}{
+		{"active", fakeRevoked{}, false, 200},
+		{"revoked", fakeRevoked{revoked: true}, true, 401},
+		{"redis down, fail closed", fakeRevoked{err: errors.New("down")}, false, 503},
+		{"redis down, fail open", fakeRevoked{err: errors.New("down")}, true, 200},
+	}
+	for _, c := range cases {
+		cfg.JTIFailOpen = c.failOpen
+		h := AuthWith(cfg, AuthDeps{Revoked: c.chk})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}))
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+raw)
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s: want %d, got %d", c.name, c.want, rec.Code)
+		}
+	}
+}
+
+type fakeAccounts struct {
+	st  repos.AccountState
+	err error
+}
+
+func (f fakeAccounts) State(context.Context, int64) (repos.AccountState, error) { return f.st, f.err }
+
+func active(ver int64) repos.AccountState {
+	return repos.AccountState{Version: ver, Status: repos.StatusActive}
+}
+
+func TestAuthWith_AccountState(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+
+	cases := []struct {
+		name  string
+		claim jwt.MapClaims
+		cur   fakeAccounts
+		want  int
+	}{
+		{"current", jwt.MapClaims{"ver": 2}, fakeAccounts{st: active(2)}, 200},
+		{"stale", jwt.MapClaims{"ver": 1}, fakeAccounts{st: active(2)}, 401},
+		{"unversioned token before any bump", nil, fakeAccounts{st: active(0)}, 200},
+		{"unversioned token after bump", nil, fakeAccounts{st: active(1)}, 401},
+		{"disabled", jwt.MapClaims{"ver": 2}, fakeAccounts{st: repos.AccountState{Version: 2, Status: repos.StatusDisabled}}, 403},
+		{"store down, fail closed", jwt.MapClaims{"ver": 2}, fakeAccounts{err: errors.New("down")}, 503},
+	}
+	for _, c := range cases {
+		h := AuthWith(cfg, AuthDeps{Accounts: c.cur})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}))
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, cfg, c.claim))
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s: want %d, got %d", c.name, c.want, rec.Code)
+		}
+	}
+}
+
+func TestRequireMFA_UsesAMRClaim(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	h := AuthWith(cfg, AuthDeps{})(RequireMFA(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})))
+
+	for _, c := range []struct {
+		amr  []string
+		want int
+	}{
+		{nil, 403},
+		{[]string{"pwd"}, 403},
+		{[]string{"pwd", "otp", "mfa"}, 200},
+	} {
+		extra := jwt.MapClaims{}
+		if c.amr != nil {
+			extra["amr"] = c.amr
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, cfg, extra))
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("amr %v: want %d, got %d", c.amr, c.want, rec.Code)
+		}
+	}
+}
+
+type fakePATs struct{ pat repos.PAT }
+
+func (f fakePATs) Lookup(_ context.Context, raw string) (repos.PAT, error) {
+	if raw != "gnp_0000abcd_secret" {
+		return repos.PAT{}, errors.New("unknown")
+	}
+	return f.pat, nil
+}
+func (fakePATs) Touch(context.Context, int64, string) error { return nil }
+
+func TestAuthWith_PersonalAccessTokenScopes(t *testing.T) {
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	deps := AuthDeps{PATs: fakePATs{repos.PAT{ID: 1, UserID: 9, Scopes: []string{"notes:read"}}}}
+	var seen int64
+	h := AuthWith(cfg, deps)(ScopeByMethod("notes:read", "notes:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = UserID(r.Context())
+		w.WriteHeader(200)
+	})))
+
+	for _, c := range []struct {
+		method, tok string
+		want        int
+	}{
+		{"GET", "gnp_0000abcd_secret", 200},
+		{"POST", "gnp_0000abcd_secret", 403},
+		{"GET", "gnp_0000abcd_wrong", 401},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.tok)
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s %s: want %d, got %d", c.method, c.tok, c.want, rec.Code)
+		}
+	}
+	if seen != 9 {
+		t.Fatalf("uid from token: %d", seen)
+	}
+}
+
+func TestAuthWith_OAuthScopeClaim(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+	notes := AuthWith(cfg, AuthDeps{})(ScopeByMethod("notes:read", "notes:write")(ok))
+	me := AuthWith(cfg, AuthDeps{})(RequireScope()(ok))
+	oauth := testToken(t, cfg, jwt.MapClaims{"client_id": "gnc_x", "scope": "notes:read"})
+
+	for _, c := range []struct {
+		h      http.Handler
+		method string
+		want   int
+	}{
+		{notes, "GET", 200},
+		{notes, "POST", 403},
+		{me, "GET", 403},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+oauth)
+		c.h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s: want %d, got %d", c.method, c.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+)
+
+// NoImpersonation refuses requests made with an impersonation token, for
+// endpoints that change credentials or grant access.
+func NoImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := Actor(r.Context()); ok {
+			apperr.Write(w, r, apperr.E(403, "impersonation_forbidden", "not allowed while impersonating", nil, nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestImpersonation_AuditedWithActorAndBlocked(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	var actor int64
+	h := Audit{DB: db}.Middleware(AuthWith(cfg, AuthDeps{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, _ = Actor(r.Context())
+		NoImpersonation(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(204) })).ServeHTTP(w, r)
+	})))
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs(user_id,actor_id,method,path,status,ip,rid)")).
+		WithArgs(int64(7), int64(1), "POST", "/me/password", 403, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs(user_id,actor_id,method,path,status,ip,rid)")).
+		WithArgs(int64(7), nil, "POST", "/me/password", 204, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	for _, c := range []struct {
+		claims jwt.MapClaims
+		actor  int64
+		want   int
+	}{
+		{jwt.MapClaims{"act": map[string]any{"sub": 1}}, 1, 403},
+		{nil, 0, 204},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/me/password", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, cfg, c.claims))
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want || actor != c.actor {
+			t.Fatalf("claims %v: status %d, actor %d", c.claims, rec.Code, actor)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestImpersonation_SessionRoutesBlocked mirrors the /me wiring in server.go:
+// the session routes sit behind NoImpersonation, the rest of /me does not.
+func TestImpersonation_SessionRoutesBlocked(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(204) }
+
+	r := chi.NewRouter()
+	r.Route("/me", func(mr chi.Router) {
+		mr.Use(AuthWith(cfg, AuthDeps{}))
+		mr.Get("/", ok)
+		mr.Group(func(cr chi.Router) {
+			cr.Use(NoImpersonation)
+			cr.Get("/sessions", ok)
+			cr.Delete("/sessions/{id}", ok)
+			cr.Post("/sessions/revoke-others", ok)
+			cr.Post("/logout-all", ok)
+		})
+	})
+
+	imp := testToken(t, cfg, jwt.MapClaims{"act": map[string]any{"sub": 1}})
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/me/sessions", 403},
+		{"DELETE", "/me/sessions/5", 403},
+		{"POST", "/me/sessions/revoke-others", 403},
+		{"POST", "/me/logout-all", 403},
+		{"GET", "/me/", 204},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("Authorization", "Bearer "+imp)
+		r.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s %s: want %d, got %d", c.method, c.path, c.want, rec.Code)
+		}
+	}
+}
+
+type accountsByID map[int64]repos.AccountState
+
+func (a accountsByID) State(_ context.Context, uid int64) (repos.AccountState, error) {
+	return a[uid], nil
+}
+
+func TestImpersonation_EndsWithActorAccount(t *testing.T) {
+	withTestKeys(t)
+	cfg := config.Config{JWTIssuer: "iss", JWTAudience: "aud"}
+
+	for _, c := range []struct {
+		name  string
+		actor repos.AccountState
+		ver   int
+		want  int
+	}{
+		{"admin active", active(3), 3, 200},
+		{"admin logged out everywhere", active(4), 3, 401},
+		{"admin disabled", repos.AccountState{Version: 3, Status: repos.StatusDisabled}, 3, 403},
+	} {
+		deps := AuthDeps{Accounts: accountsByID{7: active(0), 1: c.actor}}
+		h := AuthWith(cfg, deps)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(200) }))
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, cfg, jwt.MapClaims{"act": map[string]any{"sub": 1, "ver": c.ver}}))
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("%s: want %d, got %d", c.name, c.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+)
+
+// RequireMFA only lets through access tokens issued after a second factor.
+func RequireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(AMR(r.Context()), "mfa") {
+			apperr.Write(w, r, apperr.E(403, "mfa_required", "multi-factor authentication required", nil, nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
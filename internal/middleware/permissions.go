@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+)
+
+// PermissionSource resolves the permissions a user holds through its roles.
+type PermissionSource interface {
+	Permissions(ctx context.Context, uid int64) ([]string, error)
+}
+
+// RequirePermission lets the request through when the user holds any of
+// perms.
+func RequirePermission(src PermissionSource, perms ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := UserID(r.Context())
+			if !ok {
+				apperr.Write(w, r, apperr.Unauthorized)
+				return
+			}
+			have, err := src.Permissions(r.Context(), uid)
+			if err != nil {
+				apperr.Write(w, r, apperr.E(503, "authz_unavailable", "authorization unavailable", err, nil))
+				return
+			}
+			if !slices.ContainsFunc(perms, func(p string) bool { return slices.Contains(have, p) }) {
+				e := apperr.E(403, "forbidden", "missing permission", nil, nil)
+				e.Details = map[string]any{"required": perms}
+				apperr.Write(w, r, e)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PermissionByMethod requires read for safe methods and write otherwise.
+func PermissionByMethod(src PermissionSource, read, write string) func(http.Handler) http.Handler {
+	readMW, writeMW := RequirePermission(src, read), RequirePermission(src, write)
+	return func(next http.Handler) http.Handler {
+		rh, wh := readMW(next), writeMW(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				rh.ServeHTTP(w, r)
+			default:
+				wh.ServeHTTP(w, r)
+			}
+		})
+	}
+}
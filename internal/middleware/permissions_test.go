@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakePerms struct {
+	perms []string
+	err   error
+}
+
+func (f fakePerms) Permissions(context.Context, int64) ([]string, error) { return f.perms, f.err }
+
+func TestPermissionByMethod(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(204) })
+	cases := []struct {
+		name   string
+		src    fakePerms
+		method string
+		uid    bool
+		want   int
+	}{
+		{"read allowed", fakePerms{perms: []string{"notes:read"}}, "GET", true, 204},
+		{"write denied", fakePerms{perms: []string{"notes:read"}}, "POST", true, 403},
+		{"write allowed", fakePerms{perms: []string{"notes:read", "notes:write"}}, "DELETE", true, 204},
+		{"no user", fakePerms{perms: []string{"notes:read"}}, "GET", false, 401},
+		{"source down", fakePerms{err: errors.New("db down")}, "GET", true, 503},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/notes", nil)
+			if c.uid {
+				req = req.WithContext(context.WithValue(req.Context(), userKey, int64(7)))
+			}
+			rec := httptest.NewRecorder()
+			PermissionByMethod(c.src, "notes:read", "notes:write")(ok).ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+		})
+	}
+}
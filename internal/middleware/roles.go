@@ -1,12 +1,15 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
-
-	"github.com/Veysel440/go-notes-api/internal/repos"
 )
 
-func RequireRole(rr *repos.Roles, roles ...string) func(http.Handler) http.Handler {
+type RoleChecker interface {
+	Has(ctx context.Context, uid int64, role string) (bool, error)
+}
+
+func RequireRole(rr RoleChecker, roles ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			uid, ok := UserID(r.Context())
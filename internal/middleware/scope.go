@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+)
+
+// RequireScope lets restricted credentials through only when they hold one of
+// scopes; with no scopes listed they are refused outright. Session tokens are
+// not scoped and always pass.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			have, restricted := Scopes(r.Context())
+			if restricted && !slices.ContainsFunc(scopes, func(s string) bool { return slices.Contains(have, s) }) {
+				apperr.Write(w, r, apperr.E(403, "insufficient_scope", "token scope does not allow this request", nil, nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ScopeByMethod requires read for safe methods and write for everything else.
+// write also grants read.
+func ScopeByMethod(read, write string) func(http.Handler) http.Handler {
+	readMW, writeMW := RequireScope(read, write), RequireScope(write)
+	return func(next http.Handler) http.Handler {
+		rh, wh := readMW(next), writeMW(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				rh.ServeHTTP(w, r)
+			default:
+				wh.ServeHTTP(w, r)
+			}
+		})
+	}
+}
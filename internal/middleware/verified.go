@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func RequireVerified(users *repos.Users) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := UserID(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", 401)
+				return
+			}
+			verified, err := users.IsVerified(r.Context(), uid)
+			if err != nil || !verified {
+				apperr.Write(w, r, apperr.E(403, "email_not_verified", "email not verified", err, nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
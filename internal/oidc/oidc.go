@@ -0,0 +1,250 @@
+// Package oidc is a minimal OpenID Connect relying party: discovery,
+// authorization code flow with PKCE and ID token validation against the
+// provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNonce = errors.New("oidc: nonce mismatch")
+
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims the API cares about.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+	AMR           []string
+}
+
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	HTTP         *http.Client
+
+	mu      sync.Mutex
+	disc    *Discovery
+	keys    map[string]any
+	fetched time.Time
+}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTP != nil {
+		return p.HTTP
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (p *Provider) getJSON(ctx context.Context, uri string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", uri, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Discover fetches the provider metadata once and caches it.
+func (p *Provider) Discover(ctx context.Context) (Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.disc != nil {
+		return *p.disc, nil
+	}
+	var d Discovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return d, err
+	}
+	if d.Issuer != p.Issuer {
+		return d, fmt.Errorf("oidc: issuer mismatch: %q", d.Issuer)
+	}
+	p.disc = &d
+	return d, nil
+}
+
+func randString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewVerifier returns a PKCE code verifier; state and nonce use the same form.
+func NewVerifier() string { return randString(32) }
+
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL is where the user agent is sent to sign in.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems code at the token endpoint and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	res, err := p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var out struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("oidc: token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || out.IDToken == "" {
+		return "", fmt.Errorf("oidc: token endpoint: %s %s", res.Status, out.Error)
+	}
+	return out.IDToken, nil
+}
+
+// keyfunc resolves the signing key by kid, refetching the JWKS at most once a
+// minute when the kid is unknown (the provider rotated).
+func (p *Provider) keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		p.mu.Lock()
+		k, ok := p.keys[kid]
+		stale := time.Since(p.fetched) > time.Minute
+		p.mu.Unlock()
+		if ok {
+			return k, nil
+		}
+		if !stale {
+			return nil, fmt.Errorf("oidc: unknown kid %q", kid)
+		}
+		d, err := p.Discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var set jwks
+		if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+			return nil, err
+		}
+		keys := set.keys()
+		p.mu.Lock()
+		p.keys, p.fetched = keys, time.Now()
+		p.mu.Unlock()
+		if k, ok := keys[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("oidc: unknown kid %q", kid)
+	}
+}
+
+// Verify validates an ID token: signature against the provider JWKS, issuer,
+// audience, expiry and nonce.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Claims, error) {
+	mc := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, mc, p.keyfunc(ctx),
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer), jwt.WithAudience(p.ClientID), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return Claims{}, err
+	}
+	if n, _ := mc["nonce"].(string); n == "" || n != nonce {
+		return Claims{}, ErrNonce
+	}
+	c := Claims{}
+	c.Subject, _ = mc["sub"].(string)
+	c.Email, _ = mc["email"].(string)
+	c.Name, _ = mc["name"].(string)
+	switch v := mc["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+	groups := p.GroupsClaim
+	if groups == "" {
+		groups = "groups"
+	}
+	c.Groups = stringList(mc[groups])
+	c.AMR = stringList(mc["amr"])
+	if c.Subject == "" {
+		return Claims{}, errors.New("oidc: missing sub")
+	}
+	return c, nil
+}
+
+func stringList(v any) []string {
+	list, _ := v.([]any)
+	out := make([]string, 0, len(list))
+	for _, x := range list {
+		if s, ok := x.(string); ok && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
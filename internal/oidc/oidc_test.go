@@ -0,0 +1,61 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Veysel440/go-notes-api/internal/oidc"
+	"github.com/Veysel440/go-notes-api/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestProvider_CodeFlowAgainstMockIdP(t *testing.T) {
+	idp := oidctest.New("notes", "s3cret")
+	defer idp.Close()
+	idp.Claims = jwt.MapClaims{"sub": "u-1", "email": "a@corp.example", "email_verified": true, "groups": []string{"staff", "admins"}}
+
+	p := &oidc.Provider{Issuer: idp.URL, ClientID: "notes", ClientSecret: "s3cret", RedirectURL: "https://api.example/cb", Scopes: []string{"openid", "email"}}
+	ctx := context.Background()
+	state, nonce, verifier := oidc.NewVerifier(), oidc.NewVerifier(), oidc.NewVerifier()
+	authURL, err := p.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noFollow.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	loc, _ := url.Parse(res.Header.Get("Location"))
+	if loc.Query().Get("state") != state {
+		t.Fatalf("state not echoed: %s", loc)
+	}
+
+	raw, err := p.Exchange(ctx, loc.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Verify(ctx, raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "u-1" || c.Email != "a@corp.example" || !c.EmailVerified || len(c.Groups) != 2 {
+		t.Fatalf("claims: %+v", c)
+	}
+	if _, err := p.Verify(ctx, raw, "other"); !errors.Is(err, oidc.ErrNonce) {
+		t.Fatalf("nonce: %v", err)
+	}
+	if _, err := p.Exchange(ctx, loc.Query().Get("code"), verifier); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+
+	other := &oidc.Provider{Issuer: idp.URL, ClientID: "someone-else"}
+	if _, err := other.Verify(ctx, raw, nonce); err == nil {
+		t.Fatal("token accepted for another audience")
+	}
+}
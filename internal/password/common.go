@@ -0,0 +1,32 @@
+package password
+
+// common lists frequent passwords and password words, most common first. The
+// rank is what an attacker pays to reach an entry.
+var common = []string{
+	"password", "123456", "qwerty", "admin", "welcome", "letmein", "monkey", "dragon", "football", "iloveyou",
+	"login", "abc", "master", "hello", "freedom", "whatever", "trustno1", "sunshine", "princess", "shadow",
+	"baseball", "superman", "michael", "secret", "starwars", "passw", "pass", "batman", "charlie", "jordan",
+	"jennifer", "hunter", "thomas", "soccer", "hockey", "killer", "george", "summer", "winter", "spring",
+	"autumn", "love", "angel", "lovely", "flower", "jessica", "ashley", "daniel", "andrew", "joshua",
+	"pepper", "ginger", "cheese", "cookie", "chocolate", "banana", "orange", "purple", "yellow", "silver",
+	"golden", "diamond", "tigger", "buster", "maggie", "bailey", "harley", "ranger", "rangers", "tennis",
+	"computer", "internet", "google", "apple", "samsung", "microsoft", "windows", "linux", "server", "database",
+	"user", "guest", "root", "test", "demo", "default", "changeme", "access", "qazwsx", "zaq1",
+	"asdf", "zxcvbn", "asdfgh", "family", "friend", "friends", "mother", "father", "sister", "brother",
+	"money", "matrix", "ninja", "mustang", "ferrari", "porsche", "corvette", "camaro", "yankees", "lakers",
+	"liverpool", "chelsea", "arsenal", "barcelona", "madrid", "london", "paris", "berlin", "istanbul", "ankara",
+	"galatasaray", "fenerbahce", "besiktas", "sifre", "parola", "merhaba", "askim", "canim", "hello123", "welcome1",
+	"note", "notes", "company", "office", "work", "home", "school", "college", "student", "teacher",
+	"blink", "music", "guitar", "gaming", "player", "pokemon", "naruto", "minecraft", "fortnite", "zelda",
+	"hunter2", "qwertyuiop", "passwort", "contrasena", "motdepasse", "senha", "haslo", "salasana", "wachtwoord", "lozinka",
+}
+
+var commonRank = func() map[string]int {
+	m := make(map[string]int, len(common))
+	for i, w := range common {
+		if _, ok := m[w]; !ok {
+			m[w] = i + 1
+		}
+	}
+	return m
+}()
@@ -0,0 +1,131 @@
+// Package password hashes and verifies user passwords. New hashes use
+// argon2id in PHC string format; bcrypt hashes are still verified so existing
+// accounts keep working and are upgraded on their next login.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrFormat = errors.New("password: unknown hash format")
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+type Hasher struct {
+	// Algo is the algorithm for new hashes: "argon2id" or "bcrypt".
+	Algo       string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+func FromConfig(cfg config.Config) Hasher {
+	return Hasher{
+		Algo:       cfg.PasswordHash,
+		BcryptCost: cfg.BcryptCost,
+		Argon2: Argon2Params{
+			Memory:  cfg.Argon2Memory,
+			Time:    cfg.Argon2Time,
+			Threads: cfg.Argon2Threads,
+			SaltLen: 16,
+			KeyLen:  32,
+		},
+	}
+}
+
+var b64 = base64.RawStdEncoding
+
+func (h Hasher) Hash(pw string) (string, error) {
+	if h.Algo == "bcrypt" {
+		b, err := bcrypt.GenerateFromPassword([]byte(pw), h.BcryptCost)
+		return string(b), err
+	}
+	p := h.Argon2
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pw), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify checks pw against an encoded hash. rehash reports that the hash
+// matched but was made with another algorithm or weaker parameters than h
+// would use now, so the caller should store a fresh Hash.
+func (h Hasher) Verify(encoded, pw string) (ok, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		got := argon2.IDKey([]byte(pw), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, false, nil
+		}
+		want := h.Argon2
+		return true, h.Algo != "argon2id" || p.Memory < want.Memory || p.Time < want.Time || p.Threads < want.Threads ||
+			uint32(len(salt)) < want.SaltLen || uint32(len(key)) < want.KeyLen, nil
+	case strings.HasPrefix(encoded, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(pw)) != nil {
+			return false, false, nil
+		}
+		cost, _ := bcrypt.Cost([]byte(encoded))
+		return true, h.Algo != "bcrypt" || cost < h.BcryptCost, nil
+	}
+	return false, false, ErrFormat
+}
+
+// Limits on the parameters of a stored argon2id hash. A hash is only ever
+// written by Hash, so anything outside them is corrupt or planted, and
+// verifying it could pin a CPU or exhaust memory on a login attempt.
+const (
+	maxArgon2Memory  = 256 * 1024 // KiB
+	maxArgon2Time    = 16
+	maxArgon2Threads = 16
+	minArgon2Salt    = 8
+	minArgon2Key     = 16
+	maxArgon2Key     = 64
+)
+
+func decodeArgon2(encoded string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrFormat
+	}
+	var v int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &v); err != nil || v != argon2.Version {
+		return p, nil, nil, ErrFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrFormat
+	}
+	if p.Time == 0 || p.Time > maxArgon2Time || p.Threads == 0 || p.Threads > maxArgon2Threads ||
+		p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+		return p, nil, nil, ErrFormat
+	}
+	if salt, err = b64.DecodeString(parts[4]); err != nil || len(salt) < minArgon2Salt {
+		return p, nil, nil, ErrFormat
+	}
+	if key, err = b64.DecodeString(parts[5]); err != nil || len(key) < minArgon2Key || len(key) > maxArgon2Key {
+		return p, nil, nil, ErrFormat
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
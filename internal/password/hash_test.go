@@ -0,0 +1,64 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var fast = Hasher{Algo: "argon2id", BcryptCost: bcrypt.MinCost, Argon2: Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}}
+
+func TestHasher_Argon2idRoundTrip(t *testing.T) {
+	enc, err := fast.Hash("correct horse")
+	if err != nil || !strings.HasPrefix(enc, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash: %q %v", enc, err)
+	}
+	if ok, rehash, err := fast.Verify(enc, "correct horse"); !ok || rehash || err != nil {
+		t.Fatalf("verify: %v %v %v", ok, rehash, err)
+	}
+	if ok, _, _ := fast.Verify(enc, "wrong"); ok {
+		t.Fatal("wrong password accepted")
+	}
+
+	stronger := fast
+	stronger.Argon2.Time = 2
+	if ok, rehash, _ := stronger.Verify(enc, "correct horse"); !ok || !rehash {
+		t.Fatal("weaker parameters should ask for a rehash")
+	}
+}
+
+func TestHasher_BcryptUpgradesToArgon2id(t *testing.T) {
+	b, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if ok, rehash, err := fast.Verify(string(b), "pw"); !ok || !rehash || err != nil {
+		t.Fatalf("bcrypt: %v %v %v", ok, rehash, err)
+	}
+	bc := Hasher{Algo: "bcrypt", BcryptCost: bcrypt.MinCost}
+	if ok, rehash, _ := bc.Verify(string(b), "pw"); !ok || rehash {
+		t.Fatal("bcrypt at configured cost should not be rehashed")
+	}
+	if ok, _, err := fast.Verify("", "pw"); ok || err == nil {
+		t.Fatal("empty hash must never match")
+	}
+}
+
+func TestHasher_RejectsOutOfBoundsArgon2Params(t *testing.T) {
+	enc, _ := fast.Hash("pw")
+	tail := enc[strings.Index(enc, "p=1$")+len("p=1"):]
+	for _, params := range []string{
+		"m=1024,t=0,p=1",
+		"m=1024,t=1,p=0",
+		"m=4194304,t=1,p=1",
+		"m=1024,t=1000000,p=1",
+		"m=1024,t=1,p=255",
+	} {
+		bad := "$argon2id$v=19$" + params + tail
+		if ok, _, err := fast.Verify(bad, "pw"); ok || err != ErrFormat {
+			t.Fatalf("%s: %v %v", params, ok, err)
+		}
+	}
+	long := strings.TrimSuffix(enc, enc[strings.LastIndex(enc, "$")+1:]) + strings.Repeat("A", 200)
+	if _, _, err := fast.Verify(long, "pw"); err != ErrFormat {
+		t.Fatalf("oversized key: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Veysel440/go-notes-api/internal/config"
+)
+
+// Policy decides whether a new password is acceptable.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	MinScore  int
+	// Breached is a directory in the Have I Been Pwned range format: one file
+	// per 5 hex character SHA-1 prefix, lines of "SUFFIX:COUNT". Empty
+	// disables the check.
+	Breached string
+}
+
+func PolicyFromConfig(cfg config.Config) Policy {
+	return Policy{MinLength: cfg.PasswordMinLength, MaxLength: 128, MinScore: cfg.PasswordMinScore, Breached: cfg.PasswordBreachDir}
+}
+
+// Check returns a message explaining why pw is rejected, or "" when it is
+// acceptable. email feeds the context check: the address and its parts may
+// not make up the password.
+func (p Policy) Check(pw, email string) string {
+	n := utf8.RuneCountInString(pw)
+	if n < p.MinLength || n > p.MaxLength {
+		return "must be " + strconv.Itoa(p.MinLength) + "-" + strconv.Itoa(p.MaxLength) + " characters"
+	}
+	inputs := contextWords(email)
+	lower := strings.ToLower(pw)
+	for _, w := range inputs {
+		if len(w) >= 4 && strings.Contains(lower, w) && len(w)*2 >= len(lower) {
+			return "must not be based on your email address"
+		}
+	}
+	if s := Estimate(pw, inputs...); s.Score < p.MinScore {
+		msg := "too easy to guess"
+		if s.Hint != "" {
+			msg += ": " + s.Hint
+		}
+		return msg
+	}
+	if p.Breached != "" {
+		if n, err := BreachCount(p.Breached, pw); err == nil && n > 0 {
+			return "appears in a known data breach; choose another"
+		}
+	}
+	return ""
+}
+
+// contextWords splits an email into the address, local part, domain name
+// and the alphabetic pieces of each.
+func contextWords(email string) []string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil
+	}
+	words := []string{email}
+	local, domain, _ := strings.Cut(email, "@")
+	words = append(words, local)
+	if name, _, ok := strings.Cut(domain, "."); ok {
+		words = append(words, name)
+	}
+	words = append(words, strings.FieldsFunc(local, func(r rune) bool { return !('a' <= r && r <= 'z') })...)
+	return words
+}
+
+// BreachCount looks pw up in a local HIBP range directory and returns how
+// often it was seen. Only the file of the 5 character prefix is read.
+func BreachCount(dir, pw string) (int, error) {
+	sum := sha1.Sum([]byte(pw))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	f, err := os.Open(filepath.Join(dir, h[:5]))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(dir, h[:5]+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if ok && strings.EqualFold(suffix, h[5:]) {
+			n, _ := strconv.Atoi(count)
+			return n, nil
+		}
+	}
+	return 0, sc.Err()
+}
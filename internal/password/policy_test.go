@@ -0,0 +1,53 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimate_Scores(t *testing.T) {
+	for pw, max := range map[string]int{
+		"password":   0,
+		"P@ssw0rd!":  1,
+		"qwerty123":  1,
+		"aaaaaaaaaa": 1,
+		"abcdef2019": 1,
+	} {
+		if s := Estimate(pw); s.Score > max {
+			t.Fatalf("%s: score %d (log10 %.1f), want <= %d", pw, s.Score, s.Log10, max)
+		}
+	}
+	for _, pw := range []string{"tX9#vq2LmR", "correct horse battery staple", "Kaplumbaga-Yesil-47"} {
+		if s := Estimate(pw); s.Score < 3 {
+			t.Fatalf("%s: score %d (log10 %.1f)", pw, s.Score, s.Log10)
+		}
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("Tr0ub4dor&3x"))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, h[:5]), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+h[5:]+":42\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := Policy{MinLength: 8, MaxLength: 128, MinScore: 2, Breached: dir}
+
+	for pw, want := range map[string]string{
+		"short":                 "characters",
+		"password1":             "too easy",
+		"veysel.kaya2024":       "email",
+		"Tr0ub4dor&3x":          "breach",
+		"Kaplumbaga-Yesil-47":   "",
+		"VeyselKaya!Mavi-Deniz": "",
+	} {
+		got := p.Check(pw, "veysel.kaya@example.com")
+		if (want == "") != (got == "") || !strings.Contains(got, want) {
+			t.Fatalf("%s: got %q, want %q", pw, got, want)
+		}
+	}
+}
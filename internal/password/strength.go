@@ -0,0 +1,164 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength estimates how many guesses an attacker needs, in the spirit of
+// zxcvbn: the password is split into the cheapest sequence of known patterns
+// (common passwords and words, the user's own data, keyboard runs,
+// sequences, repeats, years) and brute-forced characters, and the guesses of
+// the parts are multiplied. Score maps log10(guesses) to 0..4 with zxcvbn's
+// thresholds.
+type Strength struct {
+	Log10 float64
+	Score int
+	// Hint names the weakest pattern found, for the error message.
+	Hint string
+}
+
+const bruteforcePerChar = 10
+
+var leet = strings.NewReplacer("@", "a", "4", "a", "8", "b", "(", "c", "3", "e", "6", "g", "1", "i", "!", "i", "|", "l", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t", "2", "z")
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "qazwsxedc", "1qaz2wsx3edc", "1234567890"}
+
+type match struct {
+	log10 float64
+	hint  string
+}
+
+// Estimate scores pw. inputs are user-specific words (email parts, name)
+// that an attacker would try first.
+func Estimate(pw string, inputs ...string) Strength {
+	rs := []rune(pw)
+	n := len(rs)
+	if n == 0 {
+		return Strength{}
+	}
+	lower := []rune(strings.ToLower(pw))
+	plain := []rune(leet.Replace(string(lower)))
+	if len(plain) != n {
+		plain = lower
+	}
+
+	best := make([]float64, n+1)
+	hint := make([]string, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] + math.Log10(bruteforcePerChar)
+		hint[i] = hint[i-1]
+		for j := 0; j <= i-3; j++ {
+			m, ok := matchAt(rs[j:i], lower[j:i], plain[j:i], inputs)
+			if ok && best[j]+m.log10 < best[i] {
+				best[i] = best[j] + m.log10
+				hint[i] = m.hint
+			}
+		}
+	}
+	s := Strength{Log10: best[n], Hint: hint[n]}
+	switch g := best[n]; {
+	case g < 3:
+		s.Score = 0
+	case g < 6:
+		s.Score = 1
+	case g < 8:
+		s.Score = 2
+	case g < 10:
+		s.Score = 3
+	default:
+		s.Score = 4
+	}
+	return s
+}
+
+func matchAt(orig, lower, plain []rune, inputs []string) (match, bool) {
+	w, lw, pw := string(orig), string(lower), string(plain)
+	best := match{log10: math.Inf(1)}
+	try := func(m match) {
+		if m.log10 < best.log10 {
+			best = m
+		}
+	}
+
+	for _, in := range inputs {
+		in = strings.ToLower(in)
+		if len(in) >= 3 && (lw == in || pw == in) {
+			try(match{math.Log10(2 * caseVariations(w)), "it contains your email address or name"})
+		}
+	}
+	if rank, ok := commonRank[lw]; ok {
+		try(match{math.Log10(float64(rank) * caseVariations(w)), "it is a common password or word"})
+	} else if rank, ok := commonRank[pw]; ok {
+		try(match{math.Log10(float64(rank) * caseVariations(w) * 4), "it is a common password with predictable substitutions"})
+	}
+	for _, row := range keyboardRows {
+		if len(lw) >= 4 && (strings.Contains(row, lw) || strings.Contains(reverse(row), lw)) {
+			try(match{math.Log10(float64(len(keyboardRows) * 2 * len(lw))), "it is a keyboard pattern"})
+		}
+	}
+	if isSequence(lower) {
+		base := 26.0
+		if unicode.IsDigit(lower[0]) {
+			base = 10
+		}
+		try(match{math.Log10(base * 2 * float64(len(lower))), "it contains a sequence like abc or 123"})
+	}
+	if isRepeat(lower) {
+		try(match{math.Log10(float64(bruteforcePerChar * len(lower))), "it contains repeated characters"})
+	}
+	if len(lw) == 4 && lw >= "1900" && lw <= "2039" {
+		try(match{math.Log10(140), "it contains a year"})
+	}
+	return best, !math.IsInf(best.log10, 1)
+}
+
+// caseVariations counts the capitalisations an attacker tries for a word:
+// all lower, Capitalised and ALL UPPER are cheap, anything else is not.
+func caseVariations(w string) float64 {
+	upper := 0
+	for _, r := range w {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	first := []rune(w)[0]
+	switch {
+	case upper == 0:
+		return 1
+	case upper == 1 && unicode.IsUpper(first), upper == len([]rune(w)):
+		return 2
+	}
+	return math.Pow(2, float64(min(upper, 8)))
+}
+
+func isSequence(rs []rune) bool {
+	d := rs[1] - rs[0]
+	if d != 1 && d != -1 {
+		return false
+	}
+	for i := 2; i < len(rs); i++ {
+		if rs[i]-rs[i-1] != d {
+			return false
+		}
+	}
+	return true
+}
+
+func isRepeat(rs []rune) bool {
+	for _, r := range rs[1:] {
+		if r != rs[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	rs := []rune(s)
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return string(rs)
+}
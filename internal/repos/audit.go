@@ -3,6 +3,7 @@ package repos
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
@@ -11,20 +12,58 @@ type Audit struct{ DB *sql.DB }
 type AuditRow struct {
 	ID        int64
 	UserID    sql.NullInt64
+	ActorID   sql.NullInt64
 	Method    string
 	Path      string
 	Status    int
 	IP        string
 	RID       string
+	Action    string
+	Meta      string
 	CreatedAt time.Time
 }
 
+// AuditEvent is a security-relevant action recorded next to the request log.
+// ActorID is set when someone other than the user acted, e.g. an admin.
+type AuditEvent struct {
+	UserID  int64
+	ActorID int64
+	Action  string
+	Method  string
+	Path    string
+	Status  int
+	IP      string
+	RID     string
+	Meta    map[string]any
+}
+
+func (r Audit) Record(ctx context.Context, e AuditEvent) error {
+	var meta []byte
+	if e.Meta != nil {
+		var err error
+		if meta, err = json.Marshal(e.Meta); err != nil {
+			return err
+		}
+	}
+	var uid, actor any
+	if e.UserID != 0 {
+		uid = e.UserID
+	}
+	if e.ActorID != 0 {
+		actor = e.ActorID
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO audit_logs(user_id,actor_id,method,path,status,ip,rid,action,meta) VALUES(?,?,?,?,?,?,?,?,?)`,
+		uid, actor, e.Method, e.Path, e.Status, e.IP, e.RID, e.Action, string(meta))
+	return err
+}
+
 func (r Audit) List(ctx context.Context, from, to time.Time, limit int) ([]AuditRow, error) {
 	if limit <= 0 || limit > 1000 {
 		limit = 100
 	}
 	rows, err := r.DB.QueryContext(ctx, `
-		SELECT id, user_id, method, path, status, ip, rid, created_at
+		SELECT id, user_id, actor_id, method, path, status, ip, rid, COALESCE(action,''), COALESCE(meta,''), created_at
 		FROM audit_logs
 		WHERE created_at BETWEEN ? AND ?
 		ORDER BY id DESC
@@ -37,7 +76,7 @@ func (r Audit) List(ctx context.Context, from, to time.Time, limit int) ([]Audit
 	var out []AuditRow
 	for rows.Next() {
 		var a AuditRow
-		if err := rows.Scan(&a.ID, &a.UserID, &a.Method, &a.Path, &a.Status, &a.IP, &a.RID, &a.CreatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.UserID, &a.ActorID, &a.Method, &a.Path, &a.Status, &a.IP, &a.RID, &a.Action, &a.Meta, &a.CreatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, a)
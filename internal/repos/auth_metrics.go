@@ -2,12 +2,18 @@ package repos
 
 import "github.com/prometheus/client_golang/prometheus"
 
-type AuthMetrics struct{ Failed prometheus.Counter }
+type AuthMetrics struct {
+	Failed prometheus.Counter
+	Reuse  *prometheus.CounterVec
+}
 
 func NewAuthMetrics(reg *prometheus.Registry) *AuthMetrics {
 	c := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "auth_failed_total", Help: "failed login attempts",
 	})
-	reg.MustRegister(c)
-	return &AuthMetrics{Failed: c}
+	reuse := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "refresh_reuse_total", Help: "spent refresh tokens presented again",
+	}, []string{"outcome"})
+	reg.MustRegister(c, reuse)
+	return &AuthMetrics{Failed: c, Reuse: reuse}
 }
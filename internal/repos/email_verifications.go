@@ -0,0 +1,47 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type EmailVerifications struct{ DB *sql.DB }
+
+// Create stores a verification token for uid/email and returns its raw value.
+func (r EmailVerifications) Create(ctx context.Context, uid int64, email string, ttl time.Duration) (string, error) {
+	raw := randHex(32)
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO email_verifications(user_id,email,token_hash,expires_at) VALUES(?,?,?,?)`,
+		uid, email, resetHash(raw), time.Now().Add(ttl))
+	return raw, err
+}
+
+// Consume spends raw, together with every other outstanding token of the
+// user, and marks the account verified if its address is still the one the
+// token was sent to. sql.ErrNoRows means the token is unknown, used or
+// expired, or the address has changed since.
+func (r EmailVerifications) Consume(ctx context.Context, raw string) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var uid int64
+	var email string
+	if err := tx.QueryRowContext(ctx, `SELECT user_id, email FROM email_verifications WHERE token_hash=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE`,
+		resetHash(raw)).Scan(&uid, &email); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE email_verifications SET used_at=NOW() WHERE user_id=? AND used_at IS NULL`, uid); err != nil {
+		return 0, err
+	}
+	var one int
+	if err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id=? AND email=? FOR UPDATE`, uid, email).Scan(&one); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET email_verified_at=COALESCE(email_verified_at,NOW()) WHERE id=?`, uid); err != nil {
+		return 0, err
+	}
+	return uid, tx.Commit()
+}
@@ -0,0 +1,56 @@
+package repos_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestEmailVerifications_ConsumeChecksAddress(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := repos.EmailVerifications{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, email FROM email_verifications WHERE token_hash=?")).
+		WithArgs(sha("raw")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}).AddRow(int64(3), "a@example.com"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE email_verifications SET used_at=NOW() WHERE user_id=?")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM users WHERE id=? AND email=?")).
+		WithArgs(int64(3), "a@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET email_verified_at=")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if uid, err := r.Consume(context.Background(), "raw"); err != nil || uid != 3 {
+		t.Fatalf("consume: %d %v", uid, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, email FROM email_verifications")).
+		WithArgs(sha("raw")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email"}).AddRow(int64(3), "old@example.com"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE email_verifications SET used_at=NOW()")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM users WHERE id=? AND email=?")).
+		WithArgs(int64(3), "old@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mock.ExpectRollback()
+
+	if _, err := r.Consume(context.Background(), "raw"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("changed address: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,28 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Identities links users to accounts at external OpenID Connect providers.
+type Identities struct{ DB *sql.DB }
+
+// Find returns the user linked to (issuer, subject), or sql.ErrNoRows.
+func (r Identities) Find(ctx context.Context, issuer, subject string) (int64, error) {
+	var uid int64
+	err := r.DB.QueryRowContext(ctx, `SELECT user_id FROM user_identities WHERE issuer=? AND subject=?`, issuer, subject).Scan(&uid)
+	return uid, err
+}
+
+func (r Identities) Link(ctx context.Context, uid int64, issuer, subject, email string) error {
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO user_identities(user_id,issuer,subject,email,last_login_at) VALUES(?,?,?,?,NOW())`,
+		uid, issuer, subject, email)
+	return err
+}
+
+func (r Identities) Touch(ctx context.Context, issuer, subject, email string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE user_identities SET last_login_at=NOW(), email=? WHERE issuer=? AND subject=?`,
+		email, issuer, subject)
+	return err
+}
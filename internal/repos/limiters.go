@@ -7,10 +7,12 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func NewEmailLimiter() func(string) bool {
+func NewEmailLimiter() func(string) bool { return NewKeyLimiter(2*time.Second, 3) }
+
+func NewKeyLimiter(every time.Duration, burst int) func(string) bool {
 	var m sync.Map
 	return func(key string) bool {
-		v, _ := m.LoadOrStore(key, rate.NewLimiter(rate.Every(2*time.Second), 3))
+		v, _ := m.LoadOrStore(key, rate.NewLimiter(rate.Every(every), burst))
 		return v.(*rate.Limiter).Allow()
 	}
 }
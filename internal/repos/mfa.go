@@ -0,0 +1,137 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+)
+
+type MFA struct{ DB *sql.DB }
+
+type MFAState struct {
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+func recoveryHash(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRecoveryCodes returns n one-time codes formatted as xxxxx-xxxxx.
+func NewRecoveryCodes(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		h := randHex(5)
+		out[i] = h[:5] + "-" + h[5:]
+	}
+	return out
+}
+
+func (r MFA) Get(ctx context.Context, uid int64) (MFAState, error) {
+	var s MFAState
+	err := r.DB.QueryRowContext(ctx, `SELECT secret, confirmed_at IS NOT NULL, last_step FROM user_mfa WHERE user_id=?`, uid).
+		Scan(&s.Secret, &s.Confirmed, &s.LastStep)
+	return s, err
+}
+
+func (r MFA) Enabled(ctx context.Context, uid int64) (bool, error) {
+	s, err := r.Get(ctx, uid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return s.Confirmed, err
+}
+
+// Begin stores a pending secret, replacing an earlier unconfirmed one.
+func (r MFA) Begin(ctx context.Context, uid int64, secret string) error {
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO user_mfa(user_id,secret) VALUES(?,?)
+		ON DUPLICATE KEY UPDATE secret=IF(confirmed_at IS NULL, VALUES(secret), secret), last_step=IF(confirmed_at IS NULL, 0, last_step)`, uid, secret)
+	return err
+}
+
+// Confirm enables the pending secret, records step as used and replaces the
+// recovery codes.
+func (r MFA) Confirm(ctx context.Context, uid, step int64, codes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `UPDATE user_mfa SET confirmed_at=NOW(), last_step=? WHERE user_id=?`, step, uid); err != nil {
+		return err
+	}
+	if err := replaceRecovery(ctx, tx, uid, codes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r MFA) ReplaceRecovery(ctx context.Context, uid int64, codes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := replaceRecovery(ctx, tx, uid, codes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecovery(ctx context.Context, tx *sql.Tx, uid int64, codes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=?`, uid); err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes(user_id,code_hash) VALUES(?,?)`, uid, recoveryHash(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep records a TOTP step; it fails for a step at or before the last one
+// used, so each code works once.
+func (r MFA) UseStep(ctx context.Context, uid, step int64) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `UPDATE user_mfa SET last_step=? WHERE user_id=? AND last_step<?`, step, uid, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r MFA) UseRecovery(ctx context.Context, uid int64, code string) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at=NOW() WHERE user_id=? AND code_hash=? AND used_at IS NULL`, uid, recoveryHash(code))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r MFA) RecoveryLeft(ctx context.Context, uid int64) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id=? AND used_at IS NULL`, uid).Scan(&n)
+	return n, err
+}
+
+func (r MFA) Disable(ctx context.Context, uid int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=?`, uid); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id=?`, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
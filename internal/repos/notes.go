@@ -3,10 +3,14 @@ package repos
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Veysel440/go-notes-api/internal/metrics"
+	"github.com/Veysel440/go-notes-api/internal/textmerge"
 )
 
 type Note struct {
@@ -16,12 +20,14 @@ type Note struct {
 	Body      string       `json:"body"`
 	CreatedAt time.Time    `json:"created_at"`
 	UpdatedAt time.Time    `json:"updated_at"`
+	Version   int          `json:"version"`
 	DeletedAt sql.NullTime `json:"-"`
 }
 
 type Notes struct {
-	DB *sql.DB
-	Mx *metrics.Registry
+	DB    *sql.DB
+	Mx    *metrics.Registry
+	Cache *NoteCache
 }
 
 func (r *Notes) observe(op string, start time.Time) {
@@ -43,10 +49,169 @@ func sanitizeSort(s string) string {
 	}
 }
 
-func (r *Notes) ListFiltered(ctx context.Context, uid int64, page, size int, q, sort string) ([]Note, int64, error) {
+type NoteQuery struct {
+	Page, Size    int
+	Q, Sort       string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	TitlePrefix   string
+	HasBody       *bool
+	Fields        []string
+	Excerpt       int
+}
+
+var NoteFields = []string{"id", "title", "body", "created_at", "updated_at", "version"}
+
+func (n Note) Project(fields []string) map[string]any {
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		switch f {
+		case "id":
+			out[f] = n.ID
+		case "title":
+			out[f] = n.Title
+		case "body":
+			out[f] = n.Body
+		case "created_at":
+			out[f] = n.CreatedAt
+		case "updated_at":
+			out[f] = n.UpdatedAt
+		case "version":
+			out[f] = n.Version
+		}
+	}
+	return out
+}
+
+func (q NoteQuery) Key() string {
+	ts := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	hb := ""
+	if q.HasBody != nil {
+		hb = fmt.Sprint(*q.HasBody)
+	}
+	return strings.Join([]string{
+		strings.ToLower(q.Q), q.Sort,
+		ts(q.CreatedAfter), ts(q.CreatedBefore), ts(q.UpdatedAfter), ts(q.UpdatedBefore),
+		q.TitlePrefix, hb,
+		strings.Join(q.Fields, ","), strconv.Itoa(q.Excerpt),
+	}, "|")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (q NoteQuery) where(uid int64) (string, []any) {
+	where := "WHERE user_id=? AND deleted_at IS NULL"
+	args := []any{uid}
+	if q.Q != "" {
+		where += " AND (title LIKE ? OR body LIKE ?)"
+		like := "%" + q.Q + "%"
+		args = append(args, like, like)
+	}
+	if !q.CreatedAfter.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, q.CreatedAfter.UTC())
+	}
+	if !q.CreatedBefore.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, q.CreatedBefore.UTC())
+	}
+	if !q.UpdatedAfter.IsZero() {
+		where += " AND updated_at >= ?"
+		args = append(args, q.UpdatedAfter.UTC())
+	}
+	if !q.UpdatedBefore.IsZero() {
+		where += " AND updated_at < ?"
+		args = append(args, q.UpdatedBefore.UTC())
+	}
+	if q.TitlePrefix != "" {
+		where += " AND title LIKE ?"
+		args = append(args, likeEscaper.Replace(q.TitlePrefix)+"%")
+	}
+	if q.HasBody != nil {
+		if *q.HasBody {
+			where += " AND body <> ''"
+		} else {
+			where += " AND body = ''"
+		}
+	}
+	return where, args
+}
+
+func (q NoteQuery) columns() (string, func(*Note) []any) {
+	fields := q.Fields
+	if len(fields) == 0 {
+		fields = NoteFields
+	}
+	cols := make([]string, 0, len(fields))
+	for _, f := range fields {
+		switch f {
+		case "body":
+			if q.Excerpt > 0 {
+				cols = append(cols, fmt.Sprintf("LEFT(body,%d)", q.Excerpt))
+				continue
+			}
+			cols = append(cols, "body")
+		case "id", "title", "created_at", "updated_at", "version":
+			cols = append(cols, f)
+		}
+	}
+	scan := func(n *Note) []any {
+		dst := make([]any, 0, len(cols))
+		for _, f := range fields {
+			switch f {
+			case "id":
+				dst = append(dst, &n.ID)
+			case "title":
+				dst = append(dst, &n.Title)
+			case "body":
+				dst = append(dst, &n.Body)
+			case "created_at":
+				dst = append(dst, &n.CreatedAt)
+			case "updated_at":
+				dst = append(dst, &n.UpdatedAt)
+			case "version":
+				dst = append(dst, &n.Version)
+			}
+		}
+		return dst
+	}
+	return strings.Join(cols, ","), scan
+}
+
+func (r *Notes) ListFiltered(ctx context.Context, uid int64, nq NoteQuery) ([]Note, int64, error) {
+	if r.Cache == nil {
+		return r.listFiltered(ctx, uid, nq)
+	}
+	v, err := r.Version(ctx, uid)
+	if err != nil {
+		return nil, 0, err
+	}
+	var out cachedList
+	err = r.Cache.fetch(ctx, uid, listKey(v, nq), &out, func(ctx context.Context) (any, error) {
+		items, total, err := r.listFiltered(ctx, uid, nq)
+		return cachedList{Items: items, Total: total}, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range out.Items {
+		out.Items[i].UserID = uid
+	}
+	return out.Items, out.Total, nil
+}
+
+func (r *Notes) listFiltered(ctx context.Context, uid int64, nq NoteQuery) ([]Note, int64, error) {
 	start := time.Now()
 	defer r.observe("notes_list", start)
 
+	page, size := nq.Page, nq.Size
 	if page < 1 {
 		page = 1
 	}
@@ -54,26 +219,21 @@ func (r *Notes) ListFiltered(ctx context.Context, uid int64, page, size int, q,
 		size = 20
 	}
 
-	where := "WHERE user_id=? AND deleted_at IS NULL"
-	args := []any{uid}
-	if q != "" {
-		where += " AND (title LIKE ? OR body LIKE ?)"
-		like := "%" + q + "%"
-		args = append(args, like, like)
-	}
+	where, args := nq.where(uid)
 
 	var total int64
 	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes "+where, args...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
-	order := sanitizeSort(sort)
+	order := sanitizeSort(nq.Sort)
+	cols, scan := nq.columns()
 	args = append(args, size, (page-1)*size)
 	query := fmt.Sprintf(`
-		SELECT id,user_id,title,body,created_at,updated_at,deleted_at
+		SELECT %s
 		FROM notes %s
 		ORDER BY %s
-		LIMIT ? OFFSET ?`, where, order)
+		LIMIT ? OFFSET ?`, cols, where, order)
 
 	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -84,48 +244,247 @@ func (r *Notes) ListFiltered(ctx context.Context, uid int64, page, size int, q,
 	out := make([]Note, 0, size)
 	for rows.Next() {
 		var n Note
-		if err := rows.Scan(&n.ID, &n.UserID, &n.Title, &n.Body, &n.CreatedAt, &n.UpdatedAt, &n.DeletedAt); err != nil {
+		if err := rows.Scan(scan(&n)...); err != nil {
 			return nil, 0, err
 		}
+		n.UserID = uid
 		out = append(out, n)
 	}
 	return out, total, rows.Err()
 }
 
+type NoteVersion struct {
+	Version   int64
+	UpdatedAt time.Time
+}
+
+func (r *Notes) Version(ctx context.Context, uid int64) (NoteVersion, error) {
+	if r.Cache == nil {
+		return r.version(ctx, uid)
+	}
+	var v NoteVersion
+	err := r.Cache.fetch(ctx, uid, versionKey, &v, func(ctx context.Context) (any, error) { return r.version(ctx, uid) })
+	return v, err
+}
+
+func (r *Notes) version(ctx context.Context, uid int64) (NoteVersion, error) {
+	start := time.Now()
+	defer r.observe("notes_version", start)
+
+	var v NoteVersion
+	err := r.DB.QueryRowContext(ctx, `SELECT version, updated_at FROM note_versions WHERE user_id=?`, uid).
+		Scan(&v.Version, &v.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return NoteVersion{}, nil
+	}
+	return v, err
+}
+
+func bumpVersion(ctx context.Context, tx *sql.Tx, uid int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO note_versions(user_id,version,updated_at) VALUES(?,1,NOW(6))
+		ON DUPLICATE KEY UPDATE version=version+1, updated_at=NOW(6)`, uid)
+	return err
+}
+
 func (r *Notes) Create(ctx context.Context, uid int64, title, body string) (int64, error) {
 	start := time.Now()
 	defer r.observe("notes_create", start)
 
-	res, err := r.DB.ExecContext(ctx, `INSERT INTO notes(user_id,title,body) VALUES(?,?,?)`, uid, title, body)
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO notes(user_id,title,body) VALUES(?,?,?)`, uid, title, body)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
 	id, _ := res.LastInsertId()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO note_revisions(note_id,version,title,body) VALUES(?,1,?,?)`,
+		id, title, body); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := bumpVersion(ctx, tx, uid); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.invalidate(ctx, uid)
 	return id, nil
 }
 
 func (r *Notes) Get(ctx context.Context, uid, id int64) (Note, error) {
+	if r.Cache == nil {
+		return r.get(ctx, uid, id)
+	}
+	var n Note
+	if err := r.Cache.fetch(ctx, uid, noteKey(id), &n, func(ctx context.Context) (any, error) { return r.get(ctx, uid, id) }); err != nil {
+		return Note{}, err
+	}
+	n.UserID = uid
+	return n, nil
+}
+
+func (r *Notes) invalidate(ctx context.Context, uid int64) {
+	if r.Cache != nil {
+		r.Cache.bump(ctx, uid)
+	}
+}
+
+// Forget makes every cached entry of uid unreachable, e.g. after the user
+// was deleted; the entries themselves expire with the cache TTL.
+func (r *Notes) Forget(ctx context.Context, uid int64) {
+	r.invalidate(ctx, uid)
+}
+
+func (r *Notes) get(ctx context.Context, uid, id int64) (Note, error) {
 	start := time.Now()
 	defer r.observe("notes_get", start)
 
 	var n Note
 	err := r.DB.QueryRowContext(ctx, `
-		SELECT id,user_id,title,body,created_at,updated_at,deleted_at
+		SELECT id,user_id,title,body,created_at,updated_at,version,deleted_at
 		FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL`,
 		id, uid).
-		Scan(&n.ID, &n.UserID, &n.Title, &n.Body, &n.CreatedAt, &n.UpdatedAt, &n.DeletedAt)
+		Scan(&n.ID, &n.UserID, &n.Title, &n.Body, &n.CreatedAt, &n.UpdatedAt, &n.Version, &n.DeletedAt)
 	return n, err
 }
 
-func (r *Notes) Update(ctx context.Context, uid, id int64, title, body string) (Note, error) {
+const keepRevisions = 50
+
+var (
+	ErrBaseUnknown = errors.New("base version not found")
+	// ErrNoteBusy is returned when the note kept changing while an update
+	// was being merged.
+	ErrNoteBusy = errors.New("note changed during update")
+
+	errStale = errors.New("note version moved")
+)
+
+// mergeAttempts bounds how often Update re-merges against a note that moved
+// on while the previous merge was computed.
+const mergeAttempts = 3
+
+type MergeConflict struct {
+	Current   Note                            `json:"current"`
+	Conflicts map[string][]textmerge.Conflict `json:"conflicts"`
+}
+
+func (e *MergeConflict) Error() string { return "merge conflict" }
+
+const noteUpdateCols = `id,user_id,title,body,created_at,updated_at,version`
+
+func scanNoteRow(row *sql.Row) (Note, error) {
+	var n Note
+	err := row.Scan(&n.ID, &n.UserID, &n.Title, &n.Body, &n.CreatedAt, &n.UpdatedAt, &n.Version)
+	return n, err
+}
+
+// Update writes title and body on top of the note. A non-zero base names the
+// version the client edited; if the note has moved on since, the client's
+// changes are three-way merged with the current state, and a *MergeConflict
+// is returned when that cannot be done automatically. The merge runs before
+// the row is locked; if the note changes meanwhile it is redone, up to
+// mergeAttempts times before ErrNoteBusy.
+func (r *Notes) Update(ctx context.Context, uid, id int64, base int, title, body string) (Note, bool, error) {
 	start := time.Now()
 	defer r.observe("notes_update", start)
 
-	_, err := r.DB.ExecContext(ctx, `UPDATE notes SET title=?, body=? WHERE id=? AND user_id=? AND deleted_at IS NULL`,
-		title, body, id, uid)
+	for range mergeAttempts {
+		cur, err := scanNoteRow(r.DB.QueryRowContext(ctx, `SELECT `+noteUpdateCols+`
+			FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL`, id, uid))
+		if err != nil {
+			return Note{}, false, err
+		}
+		t, b, merged := title, body, false
+		if base > 0 && base != cur.Version {
+			if t, b, err = r.merge(ctx, cur, base, title, body); err != nil {
+				return Note{}, false, err
+			}
+			merged = true
+		}
+		n, err := r.write(ctx, uid, id, cur.Version, t, b)
+		if !errors.Is(err, errStale) {
+			return n, merged, err
+		}
+	}
+	return Note{}, false, ErrNoteBusy
+}
+
+// merge three-way merges title and body, edited from version base, with cur.
+func (r *Notes) merge(ctx context.Context, cur Note, base int, title, body string) (string, string, error) {
+	var bt, bb string
+	err := r.DB.QueryRowContext(ctx, `SELECT title, body FROM note_revisions WHERE note_id=? AND version=?`, cur.ID, base).
+		Scan(&bt, &bb)
+	if err == sql.ErrNoRows {
+		return "", "", ErrBaseUnknown
+	}
 	if err != nil {
+		return "", "", err
+	}
+	mt, ct := textmerge.Merge3(bt, cur.Title, title)
+	mb, cb := textmerge.Merge3(bb, cur.Body, body)
+	if len(ct) > 0 || len(cb) > 0 {
+		conflicts := map[string][]textmerge.Conflict{}
+		if len(ct) > 0 {
+			conflicts["title"] = ct
+		}
+		if len(cb) > 0 {
+			conflicts["body"] = cb
+		}
+		return "", "", &MergeConflict{Current: cur, Conflicts: conflicts}
+	}
+	return mt, mb, nil
+}
+
+// write stores title and body as the version after seen, or returns
+// errStale if the note is no longer at seen.
+func (r *Notes) write(ctx context.Context, uid, id int64, seen int, title, body string) (n Note, err error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Note{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	cur, err := scanNoteRow(tx.QueryRowContext(ctx, `SELECT `+noteUpdateCols+`
+		FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL FOR UPDATE`, id, uid))
+	if err != nil {
+		return Note{}, err
+	}
+	if cur.Version != seen {
+		return Note{}, errStale
+	}
+	if title == cur.Title && body == cur.Body {
+		err = tx.Commit()
+		return cur, err
+	}
+
+	next := cur.Version + 1
+	if _, err = tx.ExecContext(ctx, `UPDATE notes SET title=?, body=?, version=? WHERE id=?`, title, body, next, id); err != nil {
+		return Note{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `INSERT INTO note_revisions(note_id,version,title,body) VALUES(?,?,?,?)`,
+		id, next, title, body); err != nil {
+		return Note{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM note_revisions WHERE note_id=? AND version<=?`, id, next-keepRevisions); err != nil {
+		return Note{}, err
+	}
+	if err = bumpVersion(ctx, tx, uid); err != nil {
+		return Note{}, err
+	}
+	if err = tx.Commit(); err != nil {
 		return Note{}, err
 	}
+	r.invalidate(ctx, uid)
 	return r.Get(ctx, uid, id)
 }
 
@@ -137,7 +496,29 @@ func (r *Notes) Delete(ctx context.Context, uid, id int64) (Note, error) {
 	if err != nil {
 		return Note{}, err
 	}
-	_, err = r.DB.ExecContext(ctx, `UPDATE notes SET deleted_at=NOW() WHERE id=? AND user_id=? AND deleted_at IS NULL`,
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Note{}, err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE notes SET deleted_at=NOW() WHERE id=? AND user_id=? AND deleted_at IS NULL`,
 		id, uid)
-	return n, err
+	if err != nil {
+		_ = tx.Rollback()
+		return Note{}, err
+	}
+	if cnt, _ := res.RowsAffected(); cnt > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM note_revisions WHERE note_id=?`, id); err != nil {
+			_ = tx.Rollback()
+			return Note{}, err
+		}
+		if err := bumpVersion(ctx, tx, uid); err != nil {
+			_ = tx.Rollback()
+			return Note{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Note{}, err
+	}
+	r.invalidate(ctx, uid)
+	return n, nil
 }
@@ -0,0 +1,201 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// NoteCache is a read-through Redis cache for note reads. Every key carries
+// the user's cache generation; writes bump it instead of deleting keys, so a
+// reader that loaded from MySQL before the write can only store its result
+// under a generation nobody reads any more.
+type NoteCache struct {
+	RDB     *redis.Client
+	TTL     time.Duration
+	Timeout time.Duration
+	Mx      *metrics.Registry
+
+	mu      sync.Mutex
+	calls   map[string]*flight
+	pending map[int64]struct{}
+}
+
+type flight struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+type cachedList struct {
+	Items []Note `json:"items"`
+	Total int64  `json:"total"`
+}
+
+func genKey(uid int64) string { return "notes:g:" + strconv.FormatInt(uid, 10) }
+
+func userPrefix(kind string, uid, gen int64) string {
+	return "notes:" + kind + ":" + strconv.FormatInt(uid, 10) + ":" + strconv.FormatInt(gen, 10)
+}
+
+func noteKey(id int64) func(uid, gen int64) string {
+	return func(uid, gen int64) string { return userPrefix("n", uid, gen) + ":" + strconv.FormatInt(id, 10) }
+}
+
+func versionKey(uid, gen int64) string { return userPrefix("v", uid, gen) }
+
+func listKey(v NoteVersion, nq NoteQuery) func(uid, gen int64) string {
+	sum := sha256.Sum256([]byte(nq.Key() + "|" + strconv.Itoa(nq.Page) + "|" + strconv.Itoa(nq.Size)))
+	return func(uid, gen int64) string {
+		return userPrefix("l", uid, gen) + ":" + strconv.FormatInt(v.Version, 10) + ":" + hex.EncodeToString(sum[:8])
+	}
+}
+
+func (c *NoteCache) observe(result string) {
+	if c.Mx != nil {
+		c.Mx.ObserveCache("notes", result)
+	}
+}
+
+func (c *NoteCache) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+// generation returns the user's current cache generation. Bumps that failed
+// earlier are replayed first; while they cannot be, the cache is bypassed.
+func (c *NoteCache) generation(ctx context.Context, uid int64) (int64, error) {
+	if err := c.flush(ctx); err != nil {
+		return 0, err
+	}
+	gen, err := c.RDB.Get(ctx, genKey(uid)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return gen, err
+}
+
+// fetch reads the entry for uid into dst, falling back to load on a miss or
+// when Redis is unavailable. Concurrent misses for the same key share a single
+// load.
+func (c *NoteCache) fetch(ctx context.Context, uid int64, key func(uid, gen int64) string, dst any, load func(context.Context) (any, error)) error {
+	gen, err := c.generation(ctx, uid)
+	redisUp := err == nil
+	k := key(uid, gen)
+	if redisUp {
+		var raw []byte
+		raw, err = c.RDB.Get(ctx, k).Bytes()
+		if err == nil && json.Unmarshal(raw, dst) == nil {
+			c.observe("hit")
+			return nil
+		}
+		redisUp = err == nil || errors.Is(err, redis.Nil)
+	}
+	if redisUp {
+		c.observe("miss")
+	} else {
+		c.observe("error")
+	}
+
+	raw, err := c.do(ctx, k, func(ctx context.Context) ([]byte, error) {
+		v, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if redisUp {
+			_ = c.RDB.Set(ctx, k, b, c.TTL).Err()
+		}
+		return b, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+// do runs fn once per key for all concurrent callers. fn gets its own
+// deadline, detached from the first caller, so one cancelled request does not
+// fail every waiter; each waiter still gives up when its own ctx is done.
+func (c *NoteCache) do(ctx context.Context, key string, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*flight{}
+	}
+	f, ok := c.calls[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		c.calls[key] = f
+		go func() {
+			lctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout())
+			f.val, f.err = fn(lctx)
+			cancel()
+
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// bump moves uid to a new cache generation. A bump that cannot reach Redis is
+// kept and replayed before this process reads from the cache again.
+func (c *NoteCache) bump(ctx context.Context, uid int64) {
+	if err := c.RDB.Incr(ctx, genKey(uid)).Err(); err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = map[int64]struct{}{}
+	}
+	c.pending[uid] = struct{}{}
+	c.mu.Unlock()
+}
+
+func (c *NoteCache) flush(ctx context.Context) error {
+	c.mu.Lock()
+	uids := make([]int64, 0, len(c.pending))
+	for uid := range c.pending {
+		uids = append(uids, uid)
+	}
+	c.mu.Unlock()
+	if len(uids) == 0 {
+		return nil
+	}
+
+	pipe := c.RDB.Pipeline()
+	for _, uid := range uids {
+		pipe.Incr(ctx, genKey(uid))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for _, uid := range uids {
+		delete(c.pending, uid)
+	}
+	c.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,160 @@
+package repos_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNotes_ListFiltered_Projection(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.Notes{DB: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM notes WHERE user_id=? AND deleted_at IS NULL")).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,title,LEFT(body,40)")).
+		WithArgs(int64(7), 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "body"}).AddRow(int64(3), "t", "short"))
+
+	items, total, err := r.ListFiltered(context.Background(), 7, repos.NoteQuery{
+		Page: 1, Size: 20, Fields: []string{"id", "title", "body"}, Excerpt: 40,
+	})
+	if err != nil || total != 1 || len(items) != 1 || items[0].Body != "short" {
+		t.Fatalf("unexpected: items=%v total=%d err=%v", items, total, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotes_Get_CacheFallsBackWhenRedisDown(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 50 * time.Millisecond})
+	defer rdb.Close()
+	r := &repos.Notes{DB: db, Cache: &repos.NoteCache{RDB: rdb, TTL: time.Minute}}
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,title,body,created_at,updated_at,version,deleted_at")).
+		WithArgs(int64(3), int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "title", "body", "created_at", "updated_at", "version", "deleted_at"}).
+			AddRow(int64(3), int64(7), "t", "b", now, now, 1, nil))
+
+	n, err := r.Get(context.Background(), 7, 3)
+	if err != nil || n.ID != 3 || n.UserID != 7 || n.Title != "t" {
+		t.Fatalf("unexpected: n=%+v err=%v", n, err)
+	}
+}
+
+func TestNotes_Get_CancelledCallerDoesNotFailWaiters(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 50 * time.Millisecond})
+	defer rdb.Close()
+	r := &repos.Notes{DB: db, Cache: &repos.NoteCache{RDB: rdb, TTL: time.Minute}}
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,title,body,created_at,updated_at,version,deleted_at")).
+		WithArgs(int64(3), int64(7)).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "title", "body", "created_at", "updated_at", "version", "deleted_at"}).
+			AddRow(int64(3), int64(7), "t", "b", now, now, 1, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.Get(ctx, 7, 3)
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	n, err := r.Get(context.Background(), 7, 3)
+	if err != nil || n.ID != 3 {
+		t.Fatalf("waiter failed: n=%+v err=%v", n, err)
+	}
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotes_Delete_RemovesRevisions(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.Notes{DB: db}
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,title,body,created_at,updated_at,version,deleted_at")).
+		WithArgs(int64(3), int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "title", "body", "created_at", "updated_at", "version", "deleted_at"}).
+			AddRow(int64(3), int64(7), "t", "b", now, now, 2, nil))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE notes SET deleted_at=NOW()")).
+		WithArgs(int64(3), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM note_revisions WHERE note_id=?")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO note_versions")).
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := r.Delete(context.Background(), 7, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotes_Update_RemergesWhenNoteMovesDuringMerge(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.Notes{DB: db}
+
+	now := time.Now()
+	cols := []string{"id", "user_id", "title", "body", "created_at", "updated_at", "version"}
+	read := func(version int, body string, lock bool) {
+		q := "FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL"
+		if lock {
+			q += " FOR UPDATE"
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(q)).WithArgs(int64(3), int64(7)).WillReturnRows(sqlmock.NewRows(cols).AddRow(int64(3), int64(7), "t", body, now, now, version))
+	}
+	revision := func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT title, body FROM note_revisions WHERE note_id=? AND version=?")).
+			WithArgs(int64(3), 1).WillReturnRows(sqlmock.NewRows([]string{"title", "body"}).AddRow("t", "a\nb\nc"))
+	}
+
+	read(2, "a\nb\nC", false)
+	revision()
+	mock.ExpectBegin()
+	read(3, "A\nb\nC", true)
+	mock.ExpectRollback()
+
+	read(3, "A\nb\nC", false)
+	revision()
+	mock.ExpectBegin()
+	read(3, "A\nb\nC", true)
+	mock.ExpectCommit()
+
+	n, merged, err := r.Update(context.Background(), 7, 3, 1, "t", "A\nb\nc")
+	if err != nil || !merged || n.Version != 3 || n.Body != "A\nb\nC" {
+		t.Fatalf("update: %+v %v %v", n, merged, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
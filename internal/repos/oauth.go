@@ -0,0 +1,211 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// OAuthScopes are the scopes third-party clients may request.
+var OAuthScopes = []string{"notes:read", "notes:write"}
+
+type OAuth struct{ DB *sql.DB }
+
+type OAuthClient struct {
+	ID           int64     `json:"id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	OwnerID      int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	secretHash   string
+}
+
+// CheckSecret reports whether secret authenticates c. Public clients have no
+// secret and never match.
+func (c OAuthClient) CheckSecret(secret string) bool {
+	if c.secretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.secretHash), []byte(oauthHash(secret))) == 1
+}
+
+type OAuthCode struct {
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+}
+
+type OAuthConsent struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func oauthHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+const clientCols = `id, client_id, COALESCE(secret_hash,''), name, redirect_uris, scopes, owner_id, created_at`
+
+func scanClient(sc interface{ Scan(...any) error }) (OAuthClient, error) {
+	var c OAuthClient
+	var uris, scopes string
+	if err := sc.Scan(&c.ID, &c.ClientID, &c.secretHash, &c.Name, &uris, &scopes, &c.OwnerID, &c.CreatedAt); err != nil {
+		return c, err
+	}
+	c.RedirectURIs = strings.Fields(uris)
+	c.Scopes = strings.Fields(scopes)
+	c.Confidential = c.secretHash != ""
+	return c, nil
+}
+
+// CreateClient registers a client owned by uid. Confidential clients get a
+// secret, returned once; public clients rely on PKCE alone.
+func (r OAuth) CreateClient(ctx context.Context, uid int64, name string, uris, scopes []string, confidential bool) (OAuthClient, string, error) {
+	c := OAuthClient{ClientID: "gnc_" + randHex(12), Name: name, RedirectURIs: uris, Scopes: scopes,
+		Confidential: confidential, OwnerID: uid, CreatedAt: time.Now()}
+	var secret string
+	var hash any
+	if confidential {
+		secret = randHex(32)
+		hash = oauthHash(secret)
+	}
+	res, err := r.DB.ExecContext(ctx, `INSERT INTO oauth_clients(client_id,secret_hash,name,redirect_uris,scopes,owner_id) VALUES(?,?,?,?,?,?)`,
+		c.ClientID, hash, name, strings.Join(uris, " "), strings.Join(scopes, " "), uid)
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+	c.ID, _ = res.LastInsertId()
+	return c, secret, nil
+}
+
+func (r OAuth) Client(ctx context.Context, clientID string) (OAuthClient, error) {
+	return scanClient(r.DB.QueryRowContext(ctx, `SELECT `+clientCols+` FROM oauth_clients WHERE client_id=?`, clientID))
+}
+
+func (r OAuth) Clients(ctx context.Context, uid int64) ([]OAuthClient, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+clientCols+` FROM oauth_clients WHERE owner_id=? ORDER BY id DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []OAuthClient{}
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// DeleteClient removes a client of uid with its codes and consents. Tokens
+// already issued to it are left to the caller to revoke (see
+// RefreshTokens.RevokeClientAll).
+func (r OAuth) DeleteClient(ctx context.Context, uid int64, clientID string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM oauth_clients WHERE owner_id=? AND client_id=?`, uid, clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_codes WHERE client_id=?`, clientID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth_consents WHERE client_id=?`, clientID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateCode stores a single-use authorization code and returns its raw value.
+func (r OAuth) CreateCode(ctx context.Context, c OAuthCode, ttl time.Duration) (string, error) {
+	raw := randHex(32)
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO oauth_codes(code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES(?,?,?,?,?,?,?)`,
+		oauthHash(raw), c.ClientID, c.UserID, c.RedirectURI, c.Scope, c.CodeChallenge, time.Now().Add(ttl))
+	return raw, err
+}
+
+// ConsumeCode spends a code issued to clientID. sql.ErrNoRows means it is
+// unknown, expired, already used or belongs to another client.
+func (r OAuth) ConsumeCode(ctx context.Context, raw, clientID string) (OAuthCode, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var c OAuthCode
+	if err := tx.QueryRowContext(ctx, `SELECT client_id, user_id, redirect_uri, scope, code_challenge FROM oauth_codes
+		WHERE code_hash=? AND client_id=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE`, oauthHash(raw), clientID).
+		Scan(&c.ClientID, &c.UserID, &c.RedirectURI, &c.Scope, &c.CodeChallenge); err != nil {
+		return OAuthCode{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE oauth_codes SET used_at=NOW() WHERE code_hash=?`, oauthHash(raw)); err != nil {
+		return OAuthCode{}, err
+	}
+	return c, tx.Commit()
+}
+
+// Consent returns the scope uid granted to clientID, or sql.ErrNoRows.
+func (r OAuth) Consent(ctx context.Context, uid int64, clientID string) (string, error) {
+	var scope string
+	err := r.DB.QueryRowContext(ctx, `SELECT scope FROM oauth_consents WHERE user_id=? AND client_id=?`, uid, clientID).Scan(&scope)
+	return scope, err
+}
+
+func (r OAuth) SaveConsent(ctx context.Context, uid int64, clientID, scope string) error {
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO oauth_consents(user_id,client_id,scope) VALUES(?,?,?)
+		ON DUPLICATE KEY UPDATE scope=VALUES(scope)`, uid, clientID, scope)
+	return err
+}
+
+func (r OAuth) Consents(ctx context.Context, uid int64) ([]OAuthConsent, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT c.client_id, COALESCE(cl.name,''), c.scope, c.created_at, c.updated_at
+		FROM oauth_consents c LEFT JOIN oauth_clients cl ON cl.client_id=c.client_id
+		WHERE c.user_id=? ORDER BY c.updated_at DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []OAuthConsent{}
+	for rows.Next() {
+		var c OAuthConsent
+		if err := rows.Scan(&c.ClientID, &c.ClientName, &c.Scope, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (r OAuth) DeleteConsent(ctx context.Context, uid int64, clientID string) error {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM oauth_consents WHERE user_id=? AND client_id=?`, uid, clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
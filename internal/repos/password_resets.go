@@ -0,0 +1,56 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+type PasswordResets struct{ DB *sql.DB }
+
+func resetHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create stores a single-use reset token for uid and returns its raw value.
+func (r PasswordResets) Create(ctx context.Context, uid int64, ttl time.Duration) (string, error) {
+	raw := randHex(32)
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO password_resets(user_id,token_hash,expires_at) VALUES(?,?,?)`,
+		uid, resetHash(raw), time.Now().Add(ttl))
+	return raw, err
+}
+
+// Peek returns the user of a live reset token without spending it.
+func (r PasswordResets) Peek(ctx context.Context, raw string) (int64, error) {
+	var uid int64
+	err := r.DB.QueryRowContext(ctx, `SELECT user_id FROM password_resets WHERE token_hash=? AND used_at IS NULL AND expires_at>NOW()`,
+		resetHash(raw)).Scan(&uid)
+	return uid, err
+}
+
+// Consume spends raw and sets the user's password hash in one transaction.
+// Every other outstanding reset of the user is spent too. sql.ErrNoRows means
+// the token is unknown, used or expired.
+func (r PasswordResets) Consume(ctx context.Context, raw, passwordHash string) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var uid int64
+	if err := tx.QueryRowContext(ctx, `SELECT user_id FROM password_resets WHERE token_hash=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE`,
+		resetHash(raw)).Scan(&uid); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE password_resets SET used_at=NOW() WHERE user_id=? AND used_at IS NULL`, uid); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash=?, email_verified_at=COALESCE(email_verified_at,NOW()) WHERE id=?`, passwordHash, uid); err != nil {
+		return 0, err
+	}
+	return uid, tx.Commit()
+}
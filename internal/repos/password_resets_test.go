@@ -0,0 +1,48 @@
+package repos_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestPasswordResets_ConsumeSpendsAllAndSetsHash(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := repos.PasswordResets{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM password_resets WHERE token_hash=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE")).
+		WithArgs(sha("raw")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(int64(3)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_resets SET used_at=NOW() WHERE user_id=? AND used_at IS NULL")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password_hash=?")).
+		WithArgs("newhash", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	uid, err := r.Consume(context.Background(), "raw", "newhash")
+	if err != nil || uid != 3 {
+		t.Fatalf("consume: %d %v", uid, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM password_resets")).
+		WithArgs(sha("raw")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	if _, err := r.Consume(context.Background(), "raw", "other"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second consume: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// PATPrefix marks personal access tokens so they can be told apart from JWTs
+// and picked up by secret scanners: gnp_<8 hex id>_<40 hex secret>.
+const PATPrefix = "gnp_"
+
+var Scopes = []string{"notes:read", "notes:write", "admin"}
+
+type PATs struct{ DB *sql.DB }
+
+type PAT struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func patHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+const patCols = `id, user_id, name, prefix, scopes, expires_at, last_used_at, COALESCE(last_used_ip,''), created_at`
+
+func scanPAT(sc interface{ Scan(...any) error }) (PAT, error) {
+	var p PAT
+	var scopes string
+	var exp, last sql.NullTime
+	if err := sc.Scan(&p.ID, &p.UserID, &p.Name, &p.Prefix, &scopes, &exp, &last, &p.LastUsedIP, &p.CreatedAt); err != nil {
+		return p, err
+	}
+	p.Scopes = strings.Split(scopes, " ")
+	if exp.Valid {
+		p.ExpiresAt = &exp.Time
+	}
+	if last.Valid {
+		p.LastUsedAt = &last.Time
+	}
+	return p, nil
+}
+
+// Create stores a new token and returns its raw value, which is not kept.
+func (r PATs) Create(ctx context.Context, uid int64, name string, scopes []string, exp *time.Time) (string, PAT, error) {
+	prefix := PATPrefix + randHex(4)
+	raw := prefix + "_" + randHex(20)
+	res, err := r.DB.ExecContext(ctx, `INSERT INTO personal_access_tokens(user_id,name,prefix,token_hash,scopes,expires_at) VALUES(?,?,?,?,?,?)`,
+		uid, name, prefix, patHash(raw), strings.Join(scopes, " "), exp)
+	if err != nil {
+		return "", PAT{}, err
+	}
+	id, _ := res.LastInsertId()
+	return raw, PAT{ID: id, UserID: uid, Name: name, Prefix: prefix, Scopes: scopes, ExpiresAt: exp, CreatedAt: time.Now()}, nil
+}
+
+func (r PATs) List(ctx context.Context, uid int64) ([]PAT, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+patCols+` FROM personal_access_tokens WHERE user_id=? ORDER BY id DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PAT{}
+	for rows.Next() {
+		p, err := scanPAT(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (r PATs) Delete(ctx context.Context, uid, id int64) error {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE id=? AND user_id=?`, id, uid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAll removes every token of uid, e.g. when the user is logged out
+// everywhere.
+func (r PATs) DeleteAll(ctx context.Context, uid int64) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE user_id=?`, uid)
+	return err
+}
+
+// Lookup resolves a raw token that is neither unknown nor expired.
+func (r PATs) Lookup(ctx context.Context, raw string) (PAT, error) {
+	return scanPAT(r.DB.QueryRowContext(ctx, `SELECT `+patCols+` FROM personal_access_tokens
+		WHERE token_hash=? AND (expires_at IS NULL OR expires_at>NOW())`, patHash(raw)))
+}
+
+// Touch records use at most once a minute per token.
+func (r PATs) Touch(ctx context.Context, id int64, ip string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE personal_access_tokens SET last_used_at=NOW(), last_used_ip=?
+		WHERE id=? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)`, ip, id)
+	return err
+}
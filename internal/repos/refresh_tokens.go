@@ -2,19 +2,101 @@ package repos
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"time"
 )
 
-type RefreshTokens struct{ DB *sql.DB }
+// RefreshTokens stores only a SHA-256 (HMAC-SHA256 when Pepper is set) of each
+// token in the token column, flagged hashed=1. Rows written before hashing
+// were converted to a plain SHA-256 by migration 0030 and are flagged hashed=2
+// until they expire.
+type RefreshTokens struct {
+	DB     *sql.DB
+	Grace  time.Duration
+	Pepper []byte
+}
+
+func (r RefreshTokens) hash(token string) string {
+	if len(r.Pepper) == 0 {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	m := hmac.New(sha256.New, r.Pepper)
+	m.Write([]byte(token))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+const byToken = `((token=? AND hashed=1) OR (token=? AND hashed=2))`
+
+func (r RefreshTokens) tokenArgs(token string) []any {
+	legacy := sha256.Sum256([]byte(token))
+	return []any{r.hash(token), hex.EncodeToString(legacy[:])}
+}
 
-func (r RefreshTokens) Issue(ctx context.Context, uid int64, exp time.Time) (string, error) {
-	b := make([]byte, 32)
+type SessionMeta struct {
+	UserAgent string
+	IP        string
+	Device    string
+	AccessJTI string
+	AccessExp time.Time
+	// AMR lists the authentication methods of the login, comma separated.
+	AMR string
+	// ClientID and Scope are set for tokens issued to OAuth clients. A token
+	// only rotates for the client it was issued to.
+	ClientID string
+	Scope    string
+}
+
+type Session struct {
+	ID         int64      `json:"id"`
+	Device     string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+	AccessJTI  string     `json:"-"`
+	AccessExp  time.Time  `json:"-"`
+}
+
+// Rotation is the outcome of UseAndRotate. Reuse is set when a spent token
+// was presented again; its family has then been revoked and Token is empty.
+// Grace marks a repeat from the same client within RefreshTokens.Grace, which
+// is answered with a sibling token instead of being treated as theft.
+type Rotation struct {
+	UserID int64
+	Token  string
+	AMR    string
+	Scope  string
+	Grace  bool
+	Reuse  *Reuse
+}
+
+type Reuse struct {
+	FamilyID string
+	FirstIP  string
+	FirstUA  string
+	FirstAt  time.Time
+	Revoked  []Session
+}
+
+func randHex(n int) string {
+	b := make([]byte, n)
 	_, _ = rand.Read(b)
-	tok := hex.EncodeToString(b)
-	_, err := r.DB.ExecContext(ctx, `INSERT INTO refresh_tokens(token,user_id,expires_at,used_at) VALUES(?,?,?,NULL)`, tok, uid, exp)
+	return hex.EncodeToString(b)
+}
+
+func newRefreshToken() string { return randHex(32) }
+
+func (r RefreshTokens) Issue(ctx context.Context, uid int64, exp time.Time, m SessionMeta) (string, error) {
+	tok := newRefreshToken()
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO refresh_tokens(token,hashed,user_id,expires_at,used_at,user_agent,ip,device_name,access_jti,access_exp,family_id,amr,client_id,scope) VALUES(?,1,?,?,NULL,?,?,?,?,?,?,?,?,?)`,
+		r.hash(tok), uid, exp, m.UserAgent, m.IP, m.Device, m.AccessJTI, m.AccessExp, randHex(16), m.AMR, m.ClientID, m.Scope)
 	return tok, err
 }
 
@@ -23,62 +105,266 @@ func (r RefreshTokens) Use(ctx context.Context, token string) (int64, bool, erro
 	if err != nil {
 		return 0, false, err
 	}
-	var uid int64
+	var id, uid int64
 	var usedAt sql.NullTime
-	if err := tx.QueryRowContext(ctx, `SELECT user_id, used_at FROM refresh_tokens WHERE token=? AND expires_at>NOW() FOR UPDATE`, token).Scan(&uid, &usedAt); err != nil {
+	var family string
+	if err := tx.QueryRowContext(ctx, `SELECT id, user_id, used_at, family_id FROM refresh_tokens WHERE `+byToken+` AND expires_at>NOW() FOR UPDATE`, r.tokenArgs(token)...).Scan(&id, &uid, &usedAt, &family); err != nil {
 		_ = tx.Rollback()
 		return 0, false, err
 	}
 	reused := usedAt.Valid
 
-	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at=NOW() WHERE token=?`, token); err != nil {
+	if reused {
+		_, _ = tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE family_id=? AND used_at IS NULL`, family)
+	} else if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at=NOW() WHERE id=?`, id); err != nil {
 		_ = tx.Rollback()
 		return 0, false, err
 	}
-	if reused {
-
-		_, _ = tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id=? AND expires_at>NOW()`, uid)
-	}
 	return uid, reused, tx.Commit()
 }
 
-func (r RefreshTokens) UseAndRotate(ctx context.Context, token string, newExp time.Time) (uid int64, newTok string, reused bool, err error) {
+// UseAndRotate spends token and issues its successor in the same family. The
+// successor keeps the session's device name and start time so the chain shows
+// up as one session.
+func (r RefreshTokens) UseAndRotate(ctx context.Context, token string, newExp time.Time, m SessionMeta) (Rotation, error) {
+	var rot Rotation
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, "", false, err
+		return rot, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
+	var id int64
 	var usedAt sql.NullTime
-	if err := tx.QueryRowContext(ctx, `SELECT user_id, used_at FROM refresh_tokens WHERE token=? AND expires_at>NOW() FOR UPDATE`, token).
-		Scan(&uid, &usedAt); err != nil {
-		_ = tx.Rollback()
-		return 0, "", false, err
+	var device, family, usedIP, usedUA, client string
+	var created time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT id, user_id, used_at, device_name, created_at, family_id, COALESCE(used_ip,''), COALESCE(used_ua,''), amr, client_id, scope FROM refresh_tokens WHERE `+byToken+` AND expires_at>NOW() FOR UPDATE`, r.tokenArgs(token)...).
+		Scan(&id, &rot.UserID, &usedAt, &device, &created, &family, &usedIP, &usedUA, &rot.AMR, &client, &rot.Scope); err != nil {
+		return rot, err
 	}
-
-	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at=NOW() WHERE token=?`, token); err != nil {
-		_ = tx.Rollback()
-		return 0, "", false, err
+	if client != m.ClientID {
+		return Rotation{}, sql.ErrNoRows
 	}
 
-	if usedAt.Valid {
-		_, _ = tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id=? AND expires_at>NOW()`, uid)
-		if err := tx.Commit(); err != nil {
-			return 0, "", true, err
+	switch {
+	case usedAt.Valid && r.Grace > 0 && time.Since(usedAt.Time) <= r.Grace && usedIP == m.IP && usedUA == m.UserAgent:
+		rot.Grace = true
+	case usedAt.Valid:
+		rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+			WHERE family_id=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE`, family)
+		if err != nil {
+			return rot, err
+		}
+		revoked, err := scanSessions(rows, "")
+		if err != nil {
+			return rot, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE family_id=? AND used_at IS NULL`, family); err != nil {
+			return rot, err
+		}
+		rot.Reuse = &Reuse{FamilyID: family, FirstIP: usedIP, FirstUA: usedUA, FirstAt: usedAt.Time, Revoked: revoked}
+		return rot, tx.Commit()
+	default:
+		if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at=NOW(), used_ip=?, used_ua=? WHERE id=?`, m.IP, m.UserAgent, id); err != nil {
+			return rot, err
 		}
-		return uid, "", true, nil
 	}
 
-	b := make([]byte, 32)
-	_, _ = rand.Read(b)
-	newTok = hex.EncodeToString(b)
-	if _, err := tx.ExecContext(ctx, `INSERT INTO refresh_tokens(token,user_id,expires_at,used_at) VALUES(?,?,?,NULL)`, newTok, uid, newExp); err != nil {
-		_ = tx.Rollback()
-		return 0, "", false, err
+	rot.Token = newRefreshToken()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO refresh_tokens(token,hashed,user_id,expires_at,used_at,user_agent,ip,device_name,created_at,last_used_at,access_jti,access_exp,family_id,amr,client_id,scope) VALUES(?,1,?,?,NULL,?,?,?,?,NOW(),?,?,?,?,?,?)`,
+		r.hash(rot.Token), rot.UserID, newExp, m.UserAgent, m.IP, device, created, m.AccessJTI, m.AccessExp, family, rot.AMR, client, rot.Scope); err != nil {
+		return Rotation{}, err
 	}
-	return uid, newTok, false, tx.Commit()
+	return rot, tx.Commit()
 }
 
 func (r RefreshTokens) Revoke(ctx context.Context, token string) error {
-	_, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE token=?`, token)
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE `+byToken, r.tokenArgs(token)...)
 	return err
 }
+
+const sessionCols = `id, device_name, user_agent, ip, created_at, last_used_at, expires_at, COALESCE(access_jti,''), access_exp`
+
+func scanSessions(rows *sql.Rows, currentJTI string) ([]Session, error) {
+	defer rows.Close()
+	out := []Session{}
+	for rows.Next() {
+		var s Session
+		var last, aexp sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Device, &s.UserAgent, &s.IP, &s.CreatedAt, &last, &s.ExpiresAt, &s.AccessJTI, &aexp); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			s.LastUsedAt = &last.Time
+		}
+		s.AccessExp = aexp.Time
+		s.Current = currentJTI != "" && s.AccessJTI == currentJTI
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r RefreshTokens) Sessions(ctx context.Context, uid int64, currentJTI string) ([]Session, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+		WHERE user_id=? AND client_id='' AND used_at IS NULL AND expires_at>NOW()
+		ORDER BY COALESCE(last_used_at, created_at) DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows, currentJTI)
+}
+
+// RevokeSession deletes one active session and returns it so the caller can
+// revoke its access token. sql.ErrNoRows means it is not the user's session.
+func (r RefreshTokens) RevokeSession(ctx context.Context, uid, id int64) (Session, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Session{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+		WHERE id=? AND user_id=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE`, id, uid)
+	if err != nil {
+		return Session{}, err
+	}
+	ss, err := scanSessions(rows, "")
+	if err != nil {
+		return Session{}, err
+	}
+	if len(ss) == 0 {
+		return Session{}, sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE id=?`, id); err != nil {
+		return Session{}, err
+	}
+	return ss[0], tx.Commit()
+}
+
+// RevokeOthers deletes every active session except the one whose access token
+// is keepJTI and returns the deleted sessions.
+func (r RefreshTokens) RevokeOthers(ctx context.Context, uid int64, keepJTI string) ([]Session, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+		WHERE user_id=? AND client_id='' AND used_at IS NULL AND expires_at>NOW() AND COALESCE(access_jti,'')<>? FOR UPDATE`, uid, keepJTI)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := scanSessions(rows, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens
+		WHERE user_id=? AND client_id='' AND used_at IS NULL AND COALESCE(access_jti,'')<>?`, uid, keepJTI); err != nil {
+		return nil, err
+	}
+	return ss, tx.Commit()
+}
+
+// RevokeAll deletes every refresh token of uid and returns the sessions whose
+// access tokens may still be live, spent rotations included.
+func (r RefreshTokens) RevokeAll(ctx context.Context, uid int64) ([]Session, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+		WHERE user_id=? AND access_exp>NOW() FOR UPDATE`, uid)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := scanSessions(rows, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id=?`, uid); err != nil {
+		return nil, err
+	}
+	return ss, tx.Commit()
+}
+
+// RefreshInfo describes an active refresh token for introspection.
+type RefreshInfo struct {
+	UserID    int64
+	ClientID  string
+	Scope     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (r RefreshTokens) Lookup(ctx context.Context, token string) (RefreshInfo, error) {
+	var ri RefreshInfo
+	err := r.DB.QueryRowContext(ctx, `SELECT user_id, client_id, scope, expires_at, created_at FROM refresh_tokens
+		WHERE `+byToken+` AND used_at IS NULL AND expires_at>NOW()`, r.tokenArgs(token)...).
+		Scan(&ri.UserID, &ri.ClientID, &ri.Scope, &ri.ExpiresAt, &ri.CreatedAt)
+	return ri, err
+}
+
+// RevokeFamily deletes the active tokens in the family of token when it was
+// issued to clientID, returning them for access token revocation.
+func (r RefreshTokens) RevokeFamily(ctx context.Context, token, clientID string) ([]Session, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var family string
+	if err := tx.QueryRowContext(ctx, `SELECT family_id FROM refresh_tokens WHERE `+byToken+` AND client_id=?`,
+		append(r.tokenArgs(token), clientID)...).Scan(&family); err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens WHERE family_id=? AND access_exp>NOW() FOR UPDATE`, family)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := scanSessions(rows, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE family_id=?`, family); err != nil {
+		return nil, err
+	}
+	return ss, tx.Commit()
+}
+
+// RevokeClient ends every token the user granted to clientID.
+func (r RefreshTokens) RevokeClient(ctx context.Context, uid int64, clientID string) ([]Session, error) {
+	return r.revokeWhere(ctx, `user_id=? AND client_id=?`, uid, clientID)
+}
+
+// RevokeClientAll ends every token issued to clientID, for all users.
+func (r RefreshTokens) RevokeClientAll(ctx context.Context, clientID string) ([]Session, error) {
+	return r.revokeWhere(ctx, `client_id=?`, clientID)
+}
+
+// revokeWhere deletes the rows matching cond and returns those whose access
+// token is still live, so the caller can revoke their jti.
+func (r RefreshTokens) revokeWhere(ctx context.Context, cond string, args ...any) ([]Session, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionCols+` FROM refresh_tokens
+		WHERE `+cond+` AND access_exp>NOW() FOR UPDATE`, args...)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := scanSessions(rows, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE `+cond, args...); err != nil {
+		return nil, err
+	}
+	return ss, tx.Commit()
+}
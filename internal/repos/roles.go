@@ -3,17 +3,56 @@ package repos
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strings"
 )
 
-type Roles struct{ DB *sql.DB }
+var (
+	ErrBuiltinRole       = errors.New("builtin role")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+type Roles struct {
+	DB *sql.DB
+	// Changed is called after a successful change with the affected user, or
+	// 0 when a role definition changed and every user may be affected.
+	Changed func(ctx context.Context, uid int64)
+}
+
+func (r Roles) changed(ctx context.Context, uid int64) {
+	if r.Changed != nil {
+		r.Changed(ctx, uid)
+	}
+}
+
+// Forget reports the roles of uid as changed so caches drop them, e.g. after
+// the user was deleted.
+func (r Roles) Forget(ctx context.Context, uid int64) {
+	r.changed(ctx, uid)
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Builtin     bool     `json:"builtin"`
+	Permissions []string `json:"permissions"`
+}
+
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
 
 func (r Roles) Assign(ctx context.Context, uid int64, role string) error {
 	var rid int64
 	if err := r.DB.QueryRowContext(ctx, `SELECT id FROM roles WHERE name=?`, role).Scan(&rid); err != nil {
 		return err
 	}
-	_, err := r.DB.ExecContext(ctx, `INSERT IGNORE INTO user_roles(user_id,role_id) VALUES(?,?)`, uid, rid)
-	return err
+	if _, err := r.DB.ExecContext(ctx, `INSERT IGNORE INTO user_roles(user_id,role_id) VALUES(?,?)`, uid, rid); err != nil {
+		return err
+	}
+	r.changed(ctx, uid)
+	return nil
 }
 
 func (r Roles) Unassign(ctx context.Context, uid int64, role string) error {
@@ -21,8 +60,11 @@ func (r Roles) Unassign(ctx context.Context, uid int64, role string) error {
 	if err := r.DB.QueryRowContext(ctx, `SELECT id FROM roles WHERE name=?`, role).Scan(&rid); err != nil {
 		return err
 	}
-	_, err := r.DB.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id=? AND role_id=?`, uid, rid)
-	return err
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id=? AND role_id=?`, uid, rid); err != nil {
+		return err
+	}
+	r.changed(ctx, uid)
+	return nil
 }
 
 func (r Roles) Has(ctx context.Context, uid int64, role string) (bool, error) {
@@ -33,3 +75,173 @@ func (r Roles) Has(ctx context.Context, uid int64, role string) (bool, error) {
 		WHERE ur.user_id=? AND ro.name=?`, uid, role).Scan(&n)
 	return n > 0, err
 }
+
+func queryStrings(ctx context.Context, db *sql.DB, q string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// UserRoles returns the names of the roles assigned to uid.
+func (r Roles) UserRoles(ctx context.Context, uid int64) ([]string, error) {
+	return queryStrings(ctx, r.DB, `SELECT ro.name FROM user_roles ur JOIN roles ro ON ro.id=ur.role_id
+		WHERE ur.user_id=? ORDER BY ro.name`, uid)
+}
+
+// Permissions returns the permissions uid holds through any of its roles.
+func (r Roles) Permissions(ctx context.Context, uid int64) ([]string, error) {
+	return queryStrings(ctx, r.DB, `SELECT DISTINCT p.name FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id=ur.role_id
+		JOIN permissions p ON p.id=rp.permission_id
+		WHERE ur.user_id=? ORDER BY p.name`, uid)
+}
+
+func (r Roles) AllPermissions(ctx context.Context) ([]Permission, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (r Roles) List(ctx context.Context) ([]Role, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT ro.name, ro.description, ro.builtin, COALESCE(GROUP_CONCAT(p.name ORDER BY p.name SEPARATOR ' '),'')
+		FROM roles ro
+		LEFT JOIN role_permissions rp ON rp.role_id=ro.id
+		LEFT JOIN permissions p ON p.id=rp.permission_id
+		GROUP BY ro.id, ro.name, ro.description, ro.builtin
+		ORDER BY ro.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Role{}
+	for rows.Next() {
+		var ro Role
+		var perms string
+		if err := rows.Scan(&ro.Name, &ro.Description, &ro.Builtin, &perms); err != nil {
+			return nil, err
+		}
+		ro.Permissions = strings.Fields(perms)
+		out = append(out, ro)
+	}
+	return out, rows.Err()
+}
+
+// setPermissions replaces the permissions of role rid. ErrUnknownPermission
+// is returned if any name does not exist.
+func setPermissions(ctx context.Context, tx *sql.Tx, rid int64, perms []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id=?`, rid); err != nil {
+		return err
+	}
+	for _, p := range perms {
+		res, err := tx.ExecContext(ctx, `INSERT INTO role_permissions(role_id, permission_id) SELECT ?, id FROM permissions WHERE name=?`, rid, p)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUnknownPermission
+		}
+	}
+	return nil
+}
+
+// Create adds a custom role with the given permissions.
+func (r Roles) Create(ctx context.Context, ro Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO roles(name, description) VALUES(?,?)`, ro.Name, ro.Description)
+	if err != nil {
+		return err
+	}
+	rid, _ := res.LastInsertId()
+	if err := setPermissions(ctx, tx, rid, ro.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update replaces the description and permissions of a role. The builtin
+// admin role always keeps every permission, so it cannot be edited.
+func (r Roles) Update(ctx context.Context, ro Role) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rid int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM roles WHERE name=? FOR UPDATE`, ro.Name).Scan(&rid); err != nil {
+		return err
+	}
+	if ro.Name == "admin" {
+		return ErrBuiltinRole
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE roles SET description=? WHERE id=?`, ro.Description, rid); err != nil {
+		return err
+	}
+	if err := setPermissions(ctx, tx, rid, ro.Permissions); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.changed(ctx, 0)
+	return nil
+}
+
+// Delete removes a custom role and its assignments.
+func (r Roles) Delete(ctx context.Context, name string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rid int64
+	var builtin bool
+	if err := tx.QueryRowContext(ctx, `SELECT id, builtin FROM roles WHERE name=? FOR UPDATE`, name).Scan(&rid, &builtin); err != nil {
+		return err
+	}
+	if builtin {
+		return ErrBuiltinRole
+	}
+	for _, q := range []string{
+		`DELETE FROM user_roles WHERE role_id=?`,
+		`DELETE FROM role_permissions WHERE role_id=?`,
+		`DELETE FROM roles WHERE id=?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, rid); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.changed(ctx, 0)
+	return nil
+}
@@ -0,0 +1,145 @@
+package repos
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Veysel440/go-notes-api/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+const rolesChannel = "roles:changed"
+
+type roleEntry struct {
+	roles, perms []string
+	exp          time.Time
+}
+
+// RoleCache keeps each user's roles and permissions in process for TTL so
+// authorization does not query MySQL on every request. Role changes are
+// broadcast over Redis pub/sub and drop the entry on every replica; a lost
+// message only leaves an entry stale until it expires.
+type RoleCache struct {
+	Roles *Roles
+	RDB   *redis.Client
+	TTL   time.Duration
+	Mx    *metrics.Registry
+
+	mu  sync.RWMutex
+	m   map[int64]roleEntry
+	gen uint64
+}
+
+func (c *RoleCache) observe(result string) {
+	if c.Mx != nil {
+		c.Mx.ObserveCache("roles", result)
+	}
+}
+
+func (c *RoleCache) load(ctx context.Context, uid int64) (roleEntry, error) {
+	c.mu.RLock()
+	e, ok := c.m[uid]
+	gen := c.gen
+	c.mu.RUnlock()
+	if ok && time.Now().Before(e.exp) {
+		c.observe("hit")
+		return e, nil
+	}
+	c.observe("miss")
+
+	roles, err := c.Roles.UserRoles(ctx, uid)
+	if err != nil {
+		c.observe("error")
+		return roleEntry{}, err
+	}
+	perms, err := c.Roles.Permissions(ctx, uid)
+	if err != nil {
+		c.observe("error")
+		return roleEntry{}, err
+	}
+	e = roleEntry{roles: roles, perms: perms, exp: time.Now().Add(c.TTL)}
+	if c.TTL > 0 {
+		c.mu.Lock()
+		// An invalidation that arrived while loading wins over what was read.
+		if c.gen == gen {
+			if c.m == nil {
+				c.m = map[int64]roleEntry{}
+			}
+			c.m[uid] = e
+		}
+		c.mu.Unlock()
+	}
+	return e, nil
+}
+
+func (c *RoleCache) Has(ctx context.Context, uid int64, role string) (bool, error) {
+	e, err := c.load(ctx, uid)
+	return slices.Contains(e.roles, role), err
+}
+
+func (c *RoleCache) Permissions(ctx context.Context, uid int64) ([]string, error) {
+	e, err := c.load(ctx, uid)
+	return e.perms, err
+}
+
+// forget drops uid, or every entry when uid is 0.
+func (c *RoleCache) forget(uid int64) {
+	c.mu.Lock()
+	c.gen++
+	if uid == 0 {
+		c.m = nil
+	} else {
+		delete(c.m, uid)
+	}
+	c.mu.Unlock()
+}
+
+// Invalidate drops uid (0 for everyone) here and on the other replicas. It
+// fits Roles.Changed.
+func (c *RoleCache) Invalidate(ctx context.Context, uid int64) {
+	c.forget(uid)
+	if c.RDB != nil {
+		_ = c.RDB.Publish(ctx, rolesChannel, strconv.FormatInt(uid, 10)).Err()
+	}
+}
+
+func (c *RoleCache) Listen(ctx context.Context) {
+	if c.RDB == nil {
+		return
+	}
+	sub := c.RDB.Subscribe(ctx, rolesChannel)
+	defer sub.Close()
+
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if uid, err := strconv.ParseInt(msg.Payload, 10, 64); err == nil {
+				c.forget(uid)
+			}
+		case <-sweep.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *RoleCache) sweep() {
+	now := time.Now()
+	c.mu.Lock()
+	for k, e := range c.m {
+		if now.After(e.exp) {
+			delete(c.m, k)
+		}
+	}
+	c.mu.Unlock()
+}
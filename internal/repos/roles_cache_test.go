@@ -0,0 +1,59 @@
+package repos_test
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestRoleCache_HitsUntilInvalidated(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	roles := &repos.Roles{DB: db}
+	c := &repos.RoleCache{Roles: roles, TTL: time.Minute}
+	roles.Changed = c.Invalidate
+
+	expectLoad := func(perms ...string) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT ro.name FROM user_roles")).WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("user"))
+		rows := sqlmock.NewRows([]string{"name"})
+		for _, p := range perms {
+			rows.AddRow(p)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT p.name FROM user_roles")).WithArgs(int64(7)).WillReturnRows(rows)
+	}
+	ctx := context.Background()
+
+	expectLoad("notes:read")
+	for range 3 {
+		perms, err := c.Permissions(ctx, 7)
+		if err != nil || !slices.Equal(perms, []string{"notes:read"}) {
+			t.Fatalf("perms %v, err %v", perms, err)
+		}
+	}
+	if ok, _ := c.Has(ctx, 7, "user"); !ok {
+		t.Fatal("cached role missing")
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM roles WHERE name=?")).WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO user_roles")).WithArgs(int64(7), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := roles.Assign(ctx, 7, "editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLoad("notes:read", "notes:write")
+	perms, err := c.Permissions(ctx, 7)
+	if err != nil || !slices.Contains(perms, "notes:write") {
+		t.Fatalf("after assign: perms %v, err %v", perms, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+package repos_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestRoles_DeleteRefusesBuiltin(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.Roles{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, builtin FROM roles WHERE name=? FOR UPDATE")).
+		WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "builtin"}).AddRow(1, true))
+	mock.ExpectRollback()
+
+	if err := r.Delete(context.Background(), "admin"); !errors.Is(err, repos.ErrBuiltinRole) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoles_CreateRejectsUnknownPermission(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.Roles{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO roles(name, description)")).
+		WithArgs("editor", "").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM role_permissions WHERE role_id=?")).
+		WithArgs(int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO role_permissions(role_id, permission_id)")).
+		WithArgs(int64(5), "notes:read").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO role_permissions(role_id, permission_id)")).
+		WithArgs(int64(5), "notes:purge").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := r.Create(context.Background(), repos.Role{Name: "editor", Permissions: []string{"notes:read", "notes:purge"}})
+	if !errors.Is(err, repos.ErrUnknownPermission) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,243 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenVersionTTL bounds how long a cached state can outlive a failed write,
+// e.g. a Bump made while Redis was unreachable.
+const tokenVersionTTL = time.Minute
+
+const tokenVersionsChannel = "tv:changed"
+
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusDisabled  = "disabled"
+	// StatusDeleted is reported for users that no longer exist.
+	StatusDeleted = "deleted"
+)
+
+// AccountState is what authentication needs to know about a user on every
+// request: the token version and whether the account may sign in.
+type AccountState struct {
+	Version        int64      `json:"v"`
+	Status         string     `json:"s"`
+	SuspendedUntil *time.Time `json:"u,omitempty"`
+	Reason         string     `json:"r,omitempty"`
+}
+
+// Blocked reports whether the account is disabled or still suspended at now.
+func (s AccountState) Blocked(now time.Time) bool {
+	switch s.Status {
+	case StatusDisabled, StatusDeleted:
+		return true
+	case StatusSuspended:
+		return s.SuspendedUntil == nil || now.Before(*s.SuspendedUntil)
+	}
+	return false
+}
+
+// TokenVersions holds users.token_version, the epoch stamped into access
+// tokens as "ver", together with the account status. Bumping the version
+// invalidates every access token issued before. Reads go through Redis;
+// MySQL stays the source of truth.
+//
+// With Local set, states are also kept in process for that long so
+// authentication does not reach Redis on every request. Bump and Forget drop
+// the entry on every replica over Redis pub/sub; a lost message only leaves an
+// entry stale until it expires.
+type TokenVersions struct {
+	DB    *sql.DB
+	RDB   *redis.Client
+	Local time.Duration
+
+	mu  sync.RWMutex
+	m   map[int64]stateEntry
+	gen uint64
+}
+
+type stateEntry struct {
+	s   AccountState
+	exp time.Time
+}
+
+func tokenVersionKey(uid int64) string { return "tv:" + strconv.FormatInt(uid, 10) }
+
+func (v *TokenVersions) local(uid int64) (AccountState, uint64, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	e, ok := v.m[uid]
+	return e.s, v.gen, ok && time.Now().Before(e.exp)
+}
+
+func (v *TokenVersions) keep(uid int64, gen uint64, s AccountState) {
+	if v.Local <= 0 {
+		return
+	}
+	v.mu.Lock()
+	// An invalidation that arrived while loading wins over what was read.
+	if v.gen == gen {
+		if v.m == nil {
+			v.m = map[int64]stateEntry{}
+		}
+		v.m[uid] = stateEntry{s: s, exp: time.Now().Add(v.Local)}
+	}
+	v.mu.Unlock()
+}
+
+func (v *TokenVersions) forget(uid int64) {
+	v.mu.Lock()
+	v.gen++
+	delete(v.m, uid)
+	v.mu.Unlock()
+}
+
+// invalidate drops uid here and on the other replicas.
+func (v *TokenVersions) invalidate(ctx context.Context, uid int64) {
+	v.forget(uid)
+	if v.RDB != nil {
+		_ = v.RDB.Publish(ctx, tokenVersionsChannel, strconv.FormatInt(uid, 10)).Err()
+	}
+}
+
+func (v *TokenVersions) load(ctx context.Context, uid int64) (AccountState, error) {
+	var s AccountState
+	var until sql.NullTime
+	err := v.DB.QueryRowContext(ctx, `SELECT token_version, status, suspended_until, status_reason FROM users WHERE id=?`, uid).
+		Scan(&s.Version, &s.Status, &until, &s.Reason)
+	if until.Valid {
+		s.SuspendedUntil = &until.Time
+	}
+	return s, err
+}
+
+// cacheState writes ARGV[1] unless the cached state already carries a newer
+// version than ARGV[2], so a read-through that loaded before a Bump cannot
+// overwrite the bumped state.
+var cacheState = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	local ok, s = pcall(cjson.decode, cur)
+	if ok and type(s) == 'table' and tonumber(s.v) and tonumber(s.v) > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+return 1
+`)
+
+func (v *TokenVersions) cache(ctx context.Context, uid int64, s AccountState) error {
+	if v.RDB == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(s)
+	return cacheState.Run(ctx, v.RDB, []string{tokenVersionKey(uid)}, raw, s.Version, tokenVersionTTL.Milliseconds()).Err()
+}
+
+func (v *TokenVersions) State(ctx context.Context, uid int64) (AccountState, error) {
+	s, gen, ok := v.local(uid)
+	if ok {
+		return s, nil
+	}
+	if v.RDB != nil {
+		if raw, err := v.RDB.Get(ctx, tokenVersionKey(uid)).Bytes(); err == nil {
+			var s AccountState
+			if json.Unmarshal(raw, &s) == nil && s.Status != "" {
+				v.keep(uid, gen, s)
+				return s, nil
+			}
+		}
+	}
+	s, err := v.load(ctx, uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		s, err = AccountState{Status: StatusDeleted}, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	_ = v.cache(ctx, uid, s)
+	v.keep(uid, gen, s)
+	return s, nil
+}
+
+// Forget drops the cached state of uid, e.g. after the user was deleted.
+func (v *TokenVersions) Forget(ctx context.Context, uid int64) error {
+	defer v.invalidate(ctx, uid)
+	if v.RDB == nil {
+		return nil
+	}
+	return v.RDB.Del(ctx, tokenVersionKey(uid)).Err()
+}
+
+// Listen applies invalidations published by other replicas until ctx ends.
+func (v *TokenVersions) Listen(ctx context.Context) {
+	if v.RDB == nil {
+		return
+	}
+	sub := v.RDB.Subscribe(ctx, tokenVersionsChannel)
+	defer sub.Close()
+
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if uid, err := strconv.ParseInt(msg.Payload, 10, 64); err == nil {
+				v.forget(uid)
+			}
+		case <-sweep.C:
+			v.sweep()
+		}
+	}
+}
+
+func (v *TokenVersions) sweep() {
+	now := time.Now()
+	v.mu.Lock()
+	for k, e := range v.m {
+		if now.After(e.exp) {
+			delete(v.m, k)
+		}
+	}
+	v.mu.Unlock()
+}
+
+func (v *TokenVersions) Current(ctx context.Context, uid int64) (int64, error) {
+	s, err := v.State(ctx, uid)
+	return s.Version, err
+}
+
+// Bump increments the version of uid and returns the new value. The cached
+// state is replaced unless it is already newer; if that write fails, other
+// replicas may accept old tokens for at most tokenVersionTTL (plus Local when
+// the invalidation message is lost too).
+func (v *TokenVersions) Bump(ctx context.Context, uid int64) (int64, error) {
+	res, err := v.DB.ExecContext(ctx, `UPDATE users SET token_version=token_version+1 WHERE id=?`, uid)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	defer v.invalidate(ctx, uid)
+	s, err := v.load(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	return s.Version, v.cache(ctx, uid, s)
+}
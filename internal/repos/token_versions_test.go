@@ -0,0 +1,84 @@
+package repos_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestTokenVersions_Bump(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	v := &repos.TokenVersions{DB: db}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=token_version+1 WHERE id=?")).
+		WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT token_version, status, suspended_until, status_reason FROM users WHERE id=?")).
+		WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows([]string{"token_version", "status", "suspended_until", "status_reason"}).AddRow(3, "active", nil, ""))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=token_version+1 WHERE id=?")).
+		WithArgs(int64(8)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if n, err := v.Bump(context.Background(), 7); err != nil || n != 3 {
+		t.Fatalf("bump: %d, %v", n, err)
+	}
+	if _, err := v.Bump(context.Background(), 8); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("unknown user: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccountState_Blocked(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	for _, c := range []struct {
+		s    repos.AccountState
+		want bool
+	}{
+		{repos.AccountState{Status: repos.StatusActive}, false},
+		{repos.AccountState{Status: repos.StatusDisabled}, true},
+		{repos.AccountState{Status: repos.StatusSuspended, SuspendedUntil: &future}, true},
+		{repos.AccountState{Status: repos.StatusSuspended, SuspendedUntil: &past}, false},
+	} {
+		if got := c.s.Blocked(now); got != c.want {
+			t.Fatalf("%+v: got %v", c.s, got)
+		}
+	}
+}
+
+func TestTokenVersions_LocalCacheDroppedByBump(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	v := &repos.TokenVersions{DB: db, Local: time.Minute}
+	cols := []string{"token_version", "status", "suspended_until", "status_reason"}
+	state := regexp.QuoteMeta("SELECT token_version, status, suspended_until, status_reason FROM users WHERE id=?")
+
+	mock.ExpectQuery(state).WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows(cols).AddRow(2, "active", nil, ""))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=token_version+1 WHERE id=?")).
+		WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(state).WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows(cols).AddRow(3, "active", nil, ""))
+	mock.ExpectQuery(state).WithArgs(int64(7)).WillReturnRows(sqlmock.NewRows(cols).AddRow(3, "active", nil, ""))
+
+	ctx := context.Background()
+	for range 2 {
+		if s, err := v.State(ctx, 7); err != nil || s.Version != 2 {
+			t.Fatalf("state: %+v %v", s, err)
+		}
+	}
+	if _, err := v.Bump(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := v.State(ctx, 7); err != nil || s.Version != 3 {
+		t.Fatalf("state after bump: %+v %v", s, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,9 @@ package repos_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
 	"regexp"
 	"testing"
 	"time"
@@ -10,35 +13,189 @@ import (
 	"github.com/Veysel440/go-notes-api/internal/repos"
 )
 
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+type capture struct{ v *string }
+
+func (c capture) Match(v driver.Value) bool { *c.v, _ = v.(string); return true }
+
+func TestRefreshTokens_IssueStoresHashOnly(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.RefreshTokens{DB: db}
+
+	var stored, looked string
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens(token,hashed,")).
+		WithArgs(capture{&stored}, int64(1), sqlmock.AnyArg(), "", "", "", "", sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE ((token=? AND hashed=1) OR (token=? AND hashed=2))")).
+		WithArgs(capture{&looked}, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tok, err := r.Issue(context.Background(), 1, time.Now().Add(time.Hour), repos.SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Revoke(context.Background(), tok); err != nil {
+		t.Fatal(err)
+	}
+	if stored == tok || stored != sha(tok) || looked != stored {
+		t.Fatalf("stored %q, looked up %q for token %q", stored, looked, tok)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRefreshTokens_IssueUseRotate(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 	r := &repos.RefreshTokens{DB: db}
+	meta := repos.SessionMeta{UserAgent: "curl/8", IP: "10.0.0.1", Device: "cli", AccessJTI: "j1", AccessExp: time.Now().Add(time.Minute)}
 
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
-		WithArgs(int64(1), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs(sqlmock.AnyArg(), int64(1), sqlmock.AnyArg(), "curl/8", "10.0.0.1", "cli", "j1", sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", "").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	if _, err := r.Issue(context.Background(), 1, time.Now().Add(time.Hour)); err != nil {
+	if _, err := r.Issue(context.Background(), 1, time.Now().Add(time.Hour), meta); err != nil {
 		t.Fatal(err)
 	}
 
-	rows := sqlmock.NewRows([]string{"user_id"}).AddRow(int64(1))
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT user_id FROM refresh_tokens WHERE token=? AND revoked_at IS NULL")).
-		WithArgs(sqlmock.AnyArg()).
-		WillReturnRows(rows)
-
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET revoked_at")).
-		WithArgs(sqlmock.AnyArg()).
+	created := time.Now().Add(-time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, used_at, device_name, created_at, family_id")).
+		WithArgs(sha("tok"), sha("tok")).
+		WillReturnRows(sqlmock.NewRows(rotateCols).AddRow(int64(4), int64(1), nil, "cli", created, "fam", "", "", "pwd,otp,mfa", "", ""))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET used_at=NOW(), used_ip=?, used_ua=? WHERE id=?")).
+		WithArgs("10.0.0.2", "curl/8", int64(4)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
+	meta.AccessJTI, meta.IP = "j2", "10.0.0.2"
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
-		WithArgs(int64(1), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs(sqlmock.AnyArg(), int64(1), sqlmock.AnyArg(), "curl/8", "10.0.0.2", "cli", created, "j2", sqlmock.AnyArg(), "fam", "pwd,otp,mfa", "", "").
 		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	rot, err := r.UseAndRotate(context.Background(), "tok", time.Now().Add(time.Hour), meta)
+	if err != nil || rot.Reuse != nil || rot.Grace || rot.UserID != 1 || len(rot.Token) != 64 || rot.AMR != "pwd,otp,mfa" {
+		t.Fatalf("unexpected: %+v err=%v", rot, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	_, _, reused, err := r.UseAndRotate(context.Background(), "tok", time.Now().Add(time.Hour))
-	if err != nil || reused {
-		t.Fatalf("unexpected: reused=%v err=%v", reused, err)
+var rotateCols = []string{"id", "user_id", "used_at", "device_name", "created_at", "family_id", "used_ip", "used_ua", "amr", "client_id", "scope"}
+
+func TestRefreshTokens_ReuseRevokesOnlyFamily(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.RefreshTokens{DB: db, Grace: 10 * time.Second}
+	now := time.Now()
+	meta := repos.SessionMeta{UserAgent: "evil/1", IP: "10.9.9.9", AccessJTI: "j3"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, used_at, device_name, created_at, family_id")).
+		WithArgs(sha("tok"), sha("tok")).
+		WillReturnRows(sqlmock.NewRows(rotateCols).AddRow(int64(4), int64(1), now.Add(-time.Minute), "cli", now, "fam", "10.0.0.1", "curl/8", "", "", ""))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE family_id=? AND used_at IS NULL AND expires_at>NOW() FOR UPDATE")).
+		WithArgs("fam").
+		WillReturnRows(sqlmock.NewRows(sessionCols).
+			AddRow(int64(9), "cli", "curl/8", "10.0.0.1", now, now, now.Add(time.Hour), "j2", now.Add(time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE family_id=? AND used_at IS NULL")).
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rot, err := r.UseAndRotate(context.Background(), "tok", now.Add(time.Hour), meta)
+	if err != nil || rot.Reuse == nil || rot.Token != "" {
+		t.Fatalf("unexpected: %+v err=%v", rot, err)
+	}
+	if rot.Reuse.FamilyID != "fam" || rot.Reuse.FirstIP != "10.0.0.1" || len(rot.Reuse.Revoked) != 1 || rot.Reuse.Revoked[0].AccessJTI != "j2" {
+		t.Fatalf("unexpected reuse: %+v", rot.Reuse)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokens_GraceIssuesSibling(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.RefreshTokens{DB: db, Grace: 10 * time.Second}
+	now := time.Now()
+	meta := repos.SessionMeta{UserAgent: "curl/8", IP: "10.0.0.1", AccessJTI: "j3"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, used_at, device_name, created_at, family_id")).
+		WithArgs(sha("tok"), sha("tok")).
+		WillReturnRows(sqlmock.NewRows(rotateCols).AddRow(int64(4), int64(1), now.Add(-2*time.Second), "cli", now, "fam", "10.0.0.1", "curl/8", "", "", ""))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WithArgs(sqlmock.AnyArg(), int64(1), sqlmock.AnyArg(), "curl/8", "10.0.0.1", "cli", sqlmock.AnyArg(), "j3", sqlmock.AnyArg(), "fam", "", "", "").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	rot, err := r.UseAndRotate(context.Background(), "tok", now.Add(time.Hour), meta)
+	if err != nil || !rot.Grace || rot.Reuse != nil || rot.Token == "" {
+		t.Fatalf("unexpected: %+v err=%v", rot, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var sessionCols = []string{"id", "device_name", "user_agent", "ip", "created_at", "last_used_at", "expires_at", "access_jti", "access_exp"}
+
+func TestRefreshTokens_RevokeOthersKeepsCurrent(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.RefreshTokens{DB: db}
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("AND COALESCE(access_jti,'')<>? FOR UPDATE")).
+		WithArgs(int64(1), "cur").
+		WillReturnRows(sqlmock.NewRows(sessionCols).
+			AddRow(int64(5), "Android", "ua", "10.0.0.3", now, nil, now.Add(time.Hour), "old", now.Add(time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens")).
+		WithArgs(int64(1), "cur").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ss, err := r.RevokeOthers(context.Background(), 1, "cur")
+	if err != nil || len(ss) != 1 || ss[0].ID != 5 || ss[0].AccessJTI != "old" || ss[0].LastUsedAt != nil {
+		t.Fatalf("unexpected: %+v %v", ss, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokens_RevokeClientAllSpansUsers(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	r := &repos.RefreshTokens{DB: db}
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE client_id=? AND access_exp>NOW() FOR UPDATE")).
+		WithArgs("cli").
+		WillReturnRows(sqlmock.NewRows(sessionCols).
+			AddRow(int64(5), "", "", "", now, nil, now.Add(time.Hour), "j5", now.Add(time.Minute)).
+			AddRow(int64(9), "", "", "", now, nil, now.Add(time.Hour), "j9", now.Add(time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE client_id=?")).
+		WithArgs("cli").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	ss, err := r.RevokeClientAll(context.Background(), "cli")
+	if err != nil || len(ss) != 2 || ss[1].AccessJTI != "j9" {
+		t.Fatalf("unexpected: %+v %v", ss, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
 	}
 }
@@ -10,6 +10,7 @@ type User struct {
 	ID           int64
 	Email        string
 	PasswordHash string
+	Verified     bool
 }
 
 type Users struct{ DB *sql.DB }
@@ -23,16 +24,156 @@ func (r Users) Create(ctx context.Context, email, pass string) (int64, error) {
 	return id, nil
 }
 
+// CreateWithRoles inserts a user and assigns roles in one transaction, so a
+// failed assignment leaves no account behind. An unknown role is
+// sql.ErrNoRows.
+func (r Users) CreateWithRoles(ctx context.Context, email, pass string, roles []string) (int64, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `insert into users(email,password_hash) values(?,?)`, email, pass)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	for _, role := range roles {
+		var rid int64
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM roles WHERE name=?`, role).Scan(&rid); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO user_roles(user_id,role_id) VALUES(?,?)`, id, rid); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
 func (r Users) FindByEmail(ctx context.Context, email string) (User, error) {
 	var u User
-	err := r.DB.QueryRowContext(ctx, `select id,email,password_hash from users where email=?`, email).
-		Scan(&u.ID, &u.Email, &u.PasswordHash)
+	err := r.DB.QueryRowContext(ctx, `select id,email,password_hash,email_verified_at is not null from users where email=?`, email).
+		Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Verified)
+	return u, err
+}
+
+func (r Users) FindByID(ctx context.Context, id int64) (User, error) {
+	var u User
+	err := r.DB.QueryRowContext(ctx, `select id,email,password_hash,email_verified_at is not null from users where id=?`, id).
+		Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Verified)
 	return u, err
 }
 
+func (r Users) UpdatePassword(ctx context.Context, id int64, hash string) error {
+	_, err := r.DB.ExecContext(ctx, `update users set password_hash=? where id=?`, hash, id)
+	return err
+}
+
+func (r Users) IsVerified(ctx context.Context, id int64) (bool, error) {
+	var ok bool
+	err := r.DB.QueryRowContext(ctx, `select email_verified_at is not null from users where id=?`, id).Scan(&ok)
+	return ok, err
+}
+
+// MarkVerified verifies id if its address is still email. Verifying twice is
+// not an error; sql.ErrNoRows means the user or address no longer matches.
+func (r Users) MarkVerified(ctx context.Context, id int64, email string) error {
+	res, err := r.DB.ExecContext(ctx, `update users set email_verified_at=now() where id=? and email=? and email_verified_at is null`, id, email)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var one int
+	return r.DB.QueryRowContext(ctx, `select 1 from users where id=? and email=?`, id, email).Scan(&one)
+}
+
+// SetStatus changes the account status of id; until only applies to
+// suspensions. Callers bump the token version afterwards so the cached state
+// and live tokens follow.
+func (r Users) SetStatus(ctx context.Context, id int64, status string, until *time.Time, reason string) error {
+	res, err := r.DB.ExecContext(ctx, `update users set status=?, suspended_until=?, status_reason=?, status_changed_at=now() where id=?`,
+		status, until, reason, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var one int
+		return r.DB.QueryRowContext(ctx, `select 1 from users where id=?`, id).Scan(&one)
+	}
+	return nil
+}
+
+func (r Users) TouchLogin(ctx context.Context, id int64) error {
+	_, err := r.DB.ExecContext(ctx, `update users set last_login_at=now() where id=?`, id)
+	return err
+}
+
+type UserDetail struct {
+	ID             int64      `json:"id"`
+	Email          string     `json:"email"`
+	Verified       bool       `json:"email_verified"`
+	Status         string     `json:"status"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+	StatusReason   string     `json:"status_reason"`
+	LastLoginAt    *time.Time `json:"last_login_at"`
+	NoteCount      int64      `json:"note_count"`
+}
+
+func (r Users) Detail(ctx context.Context, id int64) (UserDetail, error) {
+	var d UserDetail
+	var until, last sql.NullTime
+	err := r.DB.QueryRowContext(ctx, `select u.id, u.email, u.email_verified_at is not null, u.status, u.suspended_until, u.status_reason, u.last_login_at,
+		(select count(*) from notes n where n.user_id=u.id and n.deleted_at is null)
+		from users u where u.id=?`, id).
+		Scan(&d.ID, &d.Email, &d.Verified, &d.Status, &until, &d.StatusReason, &last, &d.NoteCount)
+	if until.Valid {
+		d.SuspendedUntil = &until.Time
+	}
+	if last.Valid {
+		d.LastLoginAt = &last.Time
+	}
+	return d, err
+}
+
+// Delete removes id and everything it owns in one transaction. Tables
+// without a foreign key to users are cleared explicitly; the rest cascade.
+// Audit rows are kept. Cached state (notes, roles, token version) is left to
+// the caller.
+func (r Users) Delete(ctx context.Context, id int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var one int
+	if err := tx.QueryRowContext(ctx, `select 1 from users where id=? for update`, id).Scan(&one); err != nil {
+		return err
+	}
+	for _, q := range []string{
+		`delete nr from note_revisions nr join notes n on n.id=nr.note_id where n.user_id=?`,
+		`delete from notes where user_id=?`,
+		`delete from note_versions where user_id=?`,
+		`delete from refresh_tokens where user_id=?`,
+		`delete from user_roles where user_id=?`,
+		`delete from idempotency_keys where user_id=?`,
+		`delete from oauth_codes where user_id=?`,
+		`delete from users where id=?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 type UserRow struct {
-	ID    int64  `json:"id"`
-	Email string `json:"email"`
+	ID     int64  `json:"id"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
 }
 
 func (r Users) List(ctx context.Context, page, size int, q string) ([]UserRow, int64, error) {
@@ -61,7 +202,7 @@ func (r Users) List(ctx context.Context, page, size int, q string) ([]UserRow, i
 
 	args2 := append(append([]any{}, args...), size, (page-1)*size)
 	rows, err := r.DB.QueryContext(ctx,
-		"select id,email from users"+where+" order by id desc limit ? offset ?", args2...)
+		"select id,email,status from users"+where+" order by id desc limit ? offset ?", args2...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -70,7 +211,7 @@ func (r Users) List(ctx context.Context, page, size int, q string) ([]UserRow, i
 	out := make([]UserRow, 0, size)
 	for rows.Next() {
 		var u UserRow
-		if err := rows.Scan(&u.ID, &u.Email); err != nil {
+		if err := rows.Scan(&u.ID, &u.Email, &u.Status); err != nil {
 			return nil, 0, err
 		}
 		out = append(out, u)
@@ -0,0 +1,66 @@
+package repos_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Veysel440/go-notes-api/internal/repos"
+)
+
+func TestUsers_DeleteClearsOwnedRowsInOneTx(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	u := &repos.Users{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select 1 from users where id=? for update")).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	for _, q := range []string{
+		"delete nr from note_revisions nr",
+		"delete from notes where user_id=?",
+		"delete from note_versions where user_id=?",
+		"delete from refresh_tokens where user_id=?",
+		"delete from user_roles where user_id=?",
+		"delete from idempotency_keys where user_id=?",
+		"delete from oauth_codes where user_id=?",
+		"delete from users where id=?",
+	} {
+		mock.ExpectExec(regexp.QuoteMeta(q)).WithArgs(int64(9)).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	if err := u.Delete(context.Background(), 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsers_CreateWithRolesRollsBackOnUnknownRole(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	u := &repos.Users{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("insert into users(email,password_hash)")).WithArgs("a@example.com", "").
+		WillReturnResult(sqlmock.NewResult(12, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM roles WHERE name=?")).WithArgs("user").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO user_roles")).WithArgs(int64(12), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM roles WHERE name=?")).WithArgs("gone").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	if _, err := u.CreateWithRoles(context.Background(), "a@example.com", "", []string{"user", "gone"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
@@ -10,9 +11,12 @@ import (
 	"github.com/Veysel440/go-notes-api/internal/config"
 	"github.com/Veysel440/go-notes-api/internal/handlers"
 	"github.com/Veysel440/go-notes-api/internal/jti"
+	"github.com/Veysel440/go-notes-api/internal/jwtauth"
 	"github.com/Veysel440/go-notes-api/internal/logging"
+	"github.com/Veysel440/go-notes-api/internal/mail"
 	"github.com/Veysel440/go-notes-api/internal/metrics"
 	"github.com/Veysel440/go-notes-api/internal/middleware"
+	"github.com/Veysel440/go-notes-api/internal/oidc"
 	"github.com/Veysel440/go-notes-api/internal/openapi"
 	"github.com/Veysel440/go-notes-api/internal/redisx"
 	"github.com/Veysel440/go-notes-api/internal/repos"
@@ -32,17 +36,66 @@ type Server struct {
 	log  *slog.Logger
 	rdb  *redis.Client
 	jtis jti.Store
+
+	revoked  *jti.Checker
+	roles    *repos.Roles
+	authz    *repos.RoleCache
+	versions *repos.TokenVersions
+	mailer   mail.Mailer
 }
 
-func New(cfg config.Config, db *sql.DB) *Server {
-	_, _ = otelsetup.Setup(context.Background(), cfg.OTELEndpoint, cfg.OTELSample, "go-notes-api")
+// adminPermissions gate the /admin group as a whole; each route then checks
+// its own permission.
+var adminPermissions = []string{"users:read", "users:write", "users:impersonate", "roles:read", "roles:write", "audit:read", "tokens:revoke", "keys:rotate"}
+
+// New wires the server. It fails when the JWT signing keys cannot be loaded.
+func New(cfg config.Config, db *sql.DB) (*Server, error) {
 	log := logging.New()
+
+	var keys *jwtauth.DirProvider
+	if cfg.JWTKeyDir != "" {
+		var err error
+		// Retired keys must outlive the longest access token; impersonation
+		// tokens may live longer than JWT_TTL.
+		if keys, err = jwtauth.NewDirProvider(cfg.JWTKeyDir, cfg.JWTKeyAlg, max(cfg.JWTTTL, cfg.ImpersonationTTL)); err != nil {
+			return nil, fmt.Errorf("JWT_KEY_DIR: %w", err)
+		}
+		keys.Publish = jwtauth.JWKSMaxAge
+		jwtauth.SetProvider(keys)
+	} else {
+		env, err := jwtauth.LoadFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		jwtauth.SetProvider(env)
+	}
+
+	mailer, err := mail.New(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _ = otelsetup.Setup(context.Background(), cfg.OTELEndpoint, cfg.OTELSample, "go-notes-api")
 	mx := metrics.New()
 
 	rdb := redisx.New(cfg)
 	jtis := jti.Store{RDB: rdb, Prefix: cfg.JTIPrefix}
+	revoked := &jti.Checker{Store: jtis, TTL: cfg.JTICacheTTL, MaxAge: cfg.JWTTTL}
+	go revoked.Listen(context.Background())
+
+	roles := &repos.Roles{DB: db}
+	authz := &repos.RoleCache{Roles: roles, RDB: rdb, TTL: cfg.RoleCacheTTL, Mx: mx}
+	roles.Changed = authz.Invalidate
+	go authz.Listen(context.Background())
 
-	return &Server{cfg: cfg, db: db, mx: mx, log: log, rdb: rdb, jtis: jtis}
+	versions := &repos.TokenVersions{DB: db, RDB: rdb, Local: cfg.AccountCacheTTL}
+	go versions.Listen(context.Background())
+
+	if keys != nil {
+		go keys.Watch(context.Background(), cfg.JWTKeyPoll, log)
+	}
+
+	return &Server{cfg: cfg, db: db, mx: mx, log: log, rdb: rdb, jtis: jtis, revoked: revoked, roles: roles, authz: authz, versions: versions, mailer: mailer}, nil
 }
 
 func (s *Server) router() http.Handler {
@@ -72,6 +125,7 @@ func (s *Server) router() http.Handler {
 	r.Get("/healthz", hh.Live)
 	r.Get("/readyz", hh.Ready)
 	r.Get("/info", hh.Info)
+	r.Get("/.well-known/jwks.json", handlers.WellKnown{}.JWKS)
 
 	r.Group(func(gr chi.Router) {
 		gr.Use(middleware.AllowCIDR(s.cfg.MetricsAllowCIDR))
@@ -83,19 +137,52 @@ func (s *Server) router() http.Handler {
 		r.Handle("/docs", openapi.UI())
 	}
 
-	roles := &repos.Roles{DB: s.db}
+	roles := s.roles
+	versions := s.versions
+	pats := &repos.PATs{DB: s.db}
 
 	amx := repos.NewAuthMetrics(s.mx.Reg())
 	emailLimiter := repos.NewEmailLimiter()
+	users := &repos.Users{DB: s.db}
+	notes := &repos.Notes{DB: s.db, Mx: s.mx}
+	if s.rdb != nil && s.cfg.NotesCacheTTL > 0 {
+		notes.Cache = &repos.NoteCache{RDB: s.rdb, TTL: s.cfg.NotesCacheTTL, Timeout: s.cfg.DBTimeout, Mx: s.mx}
+	}
+	mfa := &repos.MFA{DB: s.db}
+	oauthRepo := &repos.OAuth{DB: s.db}
+	vf := &handlers.Verify{
+		Cfg:     s.cfg,
+		Users:   users,
+		Tokens:  &repos.EmailVerifications{DB: s.db},
+		Mailer:  s.mailer,
+		Limiter: repos.NewKeyLimiter(time.Minute, 1),
+	}
 	au := handlers.Auth{
 		Cfg:          s.cfg,
-		Users:        &repos.Users{DB: s.db},
-		Tokens:       &repos.RefreshTokens{DB: s.db},
+		Users:        users,
+		Tokens:       &repos.RefreshTokens{DB: s.db, Grace: s.cfg.RefreshReuseGrace, Pepper: s.cfg.RefreshPepper},
 		Roles:        roles,
 		EmailLimiter: emailLimiter,
 		Metrics:      amx,
 		JTIStore:     s.jtis,
 		BruteRedis:   s.rdb,
+		Audit:        &repos.Audit{DB: s.db},
+		Verify:       vf,
+		MFA:          mfa,
+		Versions:     versions,
+	}
+	pw := handlers.Password{
+		Cfg:          s.cfg,
+		Users:        users,
+		Resets:       &repos.PasswordResets{DB: s.db},
+		Tokens:       au.Tokens,
+		Versions:     versions,
+		PATs:         pats,
+		JTIStore:     s.jtis,
+		Mailer:       vf.Mailer,
+		Audit:        au.Audit,
+		EmailLimiter: emailLimiter,
+		BruteRedis:   s.rdb,
 	}
 	r.Route("/auth", func(ar chi.Router) {
 		if s.rdb != nil {
@@ -109,34 +196,107 @@ func (s *Server) router() http.Handler {
 		}
 		ar.Post("/register", au.Register)
 		ar.Post("/login", au.Login)
+		ar.Post("/login/mfa", au.LoginMFA)
 		ar.Post("/refresh", au.Refresh)
 		ar.Post("/logout", au.Logout)
+		ar.Post("/verify", vf.Confirm)
+		ar.Post("/verify/resend", vf.Resend)
+		ar.Post("/password/forgot", pw.Forgot)
+		ar.Post("/password/reset", pw.Reset)
+		if s.cfg.OIDCIssuer != "" {
+			handlers.OIDC{
+				Auth: au,
+				Provider: &oidc.Provider{
+					Issuer:       s.cfg.OIDCIssuer,
+					ClientID:     s.cfg.OIDCClientID,
+					ClientSecret: s.cfg.OIDCClientSecret,
+					RedirectURL:  s.cfg.OIDCRedirectURL,
+					Scopes:       s.cfg.OIDCScopes,
+					GroupsClaim:  s.cfg.OIDCGroupsClaim,
+				},
+				Identities: &repos.Identities{DB: s.db},
+			}.Routes(ar)
+		}
 	})
 
+	authn := middleware.AuthWith(s.cfg, middleware.AuthDeps{Revoked: s.revoked, PATs: pats, Accounts: versions, Mx: s.mx})
+
 	r.Group(func(ar chi.Router) {
-		ar.Use(middleware.AuthWith(s.cfg), middleware.RequireRole(roles, "admin"))
+		ar.Use(authn, middleware.NoImpersonation, middleware.RequireScope("admin"), middleware.RequirePermission(s.authz, adminPermissions...))
+		if s.cfg.AdminRequireMFA {
+			ar.Use(middleware.RequireMFA)
+		}
 
 		ar.Get("/admin/ping", func(w http.ResponseWriter, _ *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			_, _ = w.Write([]byte(`{"ok":true}`))
 		})
 
-		ua := handlers.AdminUsers{Cfg: s.cfg, Users: &repos.Users{DB: s.db}}
-		ar.Get("/admin/users", ua.List)
+		perm := func(p string) func(http.Handler) http.Handler { return middleware.RequirePermission(s.authz, p) }
 
-		aroles := handlers.AdminRoles{Cfg: s.cfg, Roles: roles}
-		ar.Post("/admin/users/{id}/roles", aroles.Post)
+		ua := handlers.AdminUsers{
+			Cfg:      s.cfg,
+			Users:    users,
+			Roles:    roles,
+			Tokens:   au.Tokens,
+			Resets:   pw.Resets,
+			Versions: versions,
+			PATs:     pats,
+			Notes:    notes,
+			OAuth:    oauthRepo,
+			JTIStore: s.jtis,
+			Mailer:   vf.Mailer,
+			Audit:    au.Audit,
+			Perms:    s.authz,
+		}
+		ar.With(perm("users:read")).Get("/admin/users", ua.List)
+		ar.With(perm("users:write")).Post("/admin/users", ua.Create)
+		ar.With(perm("users:read")).Get("/admin/users/{id}", ua.Get)
+		ar.With(perm("users:write")).Delete("/admin/users/{id}", ua.Delete)
+		ar.With(perm("users:write")).Post("/admin/users/{id}/password-reset", ua.ForceReset)
+		ar.With(perm("users:impersonate")).Post("/admin/users/{id}/impersonate", ua.Impersonate)
+		ar.With(perm("tokens:revoke")).Post("/admin/users/{id}/logout-all", ua.LogoutAll)
+		ar.With(perm("users:write")).Put("/admin/users/{id}/status", ua.SetStatus)
+
+		aroles := handlers.AdminRoles{Cfg: s.cfg, Roles: roles, Audit: au.Audit, Perms: s.authz}
+		ar.With(perm("users:read")).Get("/admin/users/{id}/roles", aroles.UserRoles)
+		ar.With(perm("roles:write")).Post("/admin/users/{id}/roles", aroles.Post)
+		ar.With(perm("roles:read")).Get("/admin/roles", aroles.List)
+		ar.With(perm("roles:write")).Post("/admin/roles", aroles.Create)
+		ar.With(perm("roles:write")).Put("/admin/roles/{name}", aroles.Update)
+		ar.With(perm("roles:write")).Delete("/admin/roles/{name}", aroles.Delete)
 
 		aa := handlers.AdminAudit{Cfg: s.cfg, Audit: &repos.Audit{DB: s.db}}
-		ar.Get("/admin/audit", aa.List)
+		ar.With(perm("audit:read")).Get("/admin/audit", aa.List)
 
 		aj := handlers.AdminJTI{Store: s.jtis}
-		ar.Post("/admin/jti/revoke", aj.Revoke)
+		ar.With(perm("tokens:revoke")).Post("/admin/jti/revoke", aj.Revoke)
+
+		ar.With(perm("keys:rotate")).Post("/admin/jwt/rotate", handlers.AdminKeys{}.Rotate)
+	})
+
+	oa := handlers.OAuth{Auth: au, Repo: oauthRepo, Revoked: s.revoked}
+	oa.Routes(r, authn)
+
+	ss := handlers.Sessions{Cfg: s.cfg, Tokens: au.Tokens, JTIStore: s.jtis, Versions: versions, PATs: pats, Audit: au.Audit}
+	r.Route("/me", func(mr chi.Router) {
+		mr.Use(authn, middleware.RequireScope())
+		mr.Group(func(cr chi.Router) {
+			cr.Use(middleware.NoImpersonation)
+			ss.Routes(cr)
+			cr.Post("/password", pw.Change)
+			handlers.MFA{Cfg: s.cfg, Users: users, Repo: mfa, Audit: au.Audit, BruteRedis: s.rdb}.Routes(cr)
+			handlers.PATs{Cfg: s.cfg, Repo: pats, Audit: au.Audit}.Routes(cr)
+			oa.MeRoutes(cr)
+		})
 	})
 
-	nt := handlers.Notes{Repo: &repos.Notes{DB: s.db, Mx: s.mx}}
+	nt := handlers.Notes{Repo: notes, RequireBase: s.cfg.NotesRequireBase}
+	if s.cfg.VerifyPolicy == "notes" {
+		nt.CreateGuard = middleware.RequireVerified(users)
+	}
 	r.Route("/notes", func(pr chi.Router) {
-		pr.Use(middleware.AuthWith(s.cfg), middleware.RequireRole(roles, "user"))
+		pr.Use(authn, middleware.ScopeByMethod("notes:read", "notes:write"), middleware.PermissionByMethod(s.authz, "notes:read", "notes:write"))
 		nt.Routes(pr)
 	})
 
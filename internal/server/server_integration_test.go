@@ -20,7 +20,8 @@ func Test_AdminRoleAndAudit(t *testing.T) {
 	if dsn == "" {
 		t.Skip("TEST_DSN yok; entegrasyon testi atlandı")
 	}
-	os.Setenv("DB_DSN", dsn)
+	t.Setenv("DB_DSN", dsn)
+	t.Setenv("ADMIN_REQUIRE_MFA", "false")
 
 	cfg := config.Load()
 	db, closeFn, err := dbx.OpenAndMigrate(cfg)
@@ -29,11 +30,14 @@ func Test_AdminRoleAndAudit(t *testing.T) {
 	}
 	defer func() { closeFn(); db.Close() }()
 
-	s := New(cfg, db)
+	s, err := New(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
 	ts := httptest.NewServer(s.router())
 	defer ts.Close()
 
-	body := []byte(`{"email":"admin@test.local","password":"P@ssw0rd!"}`)
+	body := []byte(`{"email":"admin@test.local","password":"Mavi-Kaplumbaga-47"}`)
 	res, err := http.Post(ts.URL+"/auth/register", "application/json", bytes.NewReader(body))
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,143 @@
+package textmerge
+
+import "strings"
+
+// maxCells bounds the LCS table (4 bytes a cell) built for the lines left
+// after the common prefix and suffix are trimmed; larger inputs are merged
+// as a single hunk.
+const maxCells = 250_000
+
+type Conflict struct {
+	Line   int      `json:"line"`
+	Base   []string `json:"base"`
+	Ours   []string `json:"ours"`
+	Theirs []string `json:"theirs"`
+}
+
+// Merge3 performs a line-based three-way merge of ours and theirs against
+// their common ancestor base. When conflicts is non-empty merged is undefined.
+func Merge3(base, ours, theirs string) (merged string, conflicts []Conflict) {
+	if ours == theirs || theirs == base {
+		return ours, nil
+	}
+	if ours == base {
+		return theirs, nil
+	}
+
+	o, a, b := split(base), split(ours), split(theirs)
+	ma, mb := matches(o, a), matches(o, b)
+
+	var out []string
+	i, ia, ib := 0, 0, 0
+	for i < len(o) || ia < len(a) || ib < len(b) {
+		if i < len(o) && ma[i] == ia && mb[i] == ib {
+			out = append(out, o[i])
+			i, ia, ib = i+1, ia+1, ib+1
+			continue
+		}
+		k := i
+		for k < len(o) && (ma[k] < 0 || mb[k] < 0) {
+			k++
+		}
+		ea, eb := len(a), len(b)
+		if k < len(o) {
+			ea, eb = ma[k], mb[k]
+		}
+		co, ca, cb := o[i:k], a[ia:ea], b[ib:eb]
+		switch {
+		case equal(ca, co):
+			out = append(out, cb...)
+		case equal(cb, co), equal(ca, cb):
+			out = append(out, ca...)
+		default:
+			conflicts = append(conflicts, Conflict{
+				Line: i + 1, Base: clone(co), Ours: clone(ca), Theirs: clone(cb),
+			})
+		}
+		i, ia, ib = k, ea, eb
+	}
+	if len(conflicts) > 0 {
+		return "", conflicts
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// matches returns, for every line of x, the index of the line in y it is
+// paired with by a longest common subsequence, or -1.
+func matches(x, y []string) []int {
+	m := make([]int, len(x))
+	for i := range m {
+		m[i] = -1
+	}
+	// Lines shared at both ends pair up in some longest common subsequence,
+	// so only the middle needs the table.
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		m[pre] = pre
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		m[len(x)-1-suf] = len(y) - 1 - suf
+		suf++
+	}
+	x, y = x[pre:len(x)-suf], y[pre:len(y)-suf]
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 || n1*n2 > maxCells {
+		return m
+	}
+	dp := make([][]int32, n1+1)
+	for i := range dp {
+		dp[i] = make([]int32, n2+1)
+	}
+	for i := n1 - 1; i >= 0; i-- {
+		for j := n2 - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] >= dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < n1 && j < n2; {
+		switch {
+		case x[i] == y[j]:
+			m[pre+i] = pre + j
+			i, j = i+1, j+1
+		case dp[i+1][j] >= dp[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return m
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func clone(s []string) []string {
+	if len(s) == 0 {
+		return []string{}
+	}
+	return append([]string(nil), s...)
+}
@@ -0,0 +1,63 @@
+package textmerge
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMerge3_NonOverlapping(t *testing.T) {
+	base := "a\nb\nc\nd"
+	ours := "A\nb\nc\nd"
+	theirs := "a\nb\nc\nD\ne"
+	got, conflicts := Merge3(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+	if want := "A\nb\nc\nD\ne"; got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestMerge3_SameChange(t *testing.T) {
+	got, conflicts := Merge3("a\nb", "a\nx", "a\nx")
+	if len(conflicts) != 0 || got != "a\nx" {
+		t.Fatalf("got %q conflicts %+v", got, conflicts)
+	}
+}
+
+func TestMerge3_Conflict(t *testing.T) {
+	_, conflicts := Merge3("a\nb\nc", "a\nours\nc", "a\ntheirs\nc")
+	if len(conflicts) != 1 {
+		t.Fatalf("want 1 conflict, got %+v", conflicts)
+	}
+	c := conflicts[0]
+	if c.Line != 2 || c.Ours[0] != "ours" || c.Theirs[0] != "theirs" || c.Base[0] != "b" {
+		t.Fatalf("unexpected hunk: %+v", c)
+	}
+}
+
+func TestMerge3_DeleteAndEdit(t *testing.T) {
+	got, conflicts := Merge3("a\nb\nc\nd", "a\nc\nd", "a\nb\nc\nd2")
+	if len(conflicts) != 0 || got != "a\nc\nd2" {
+		t.Fatalf("got %q conflicts %+v", got, conflicts)
+	}
+}
+
+func TestMerge3_LargeInputMergesEditsAtBothEnds(t *testing.T) {
+	lines := make([]string, 3000)
+	for i := range lines {
+		lines[i] = strconv.Itoa(i)
+	}
+	base := strings.Join(lines, "\n")
+	lines[5] = "ours"
+	ours := strings.Join(lines, "\n")
+	lines[5], lines[2990] = "5", "theirs"
+	theirs := strings.Join(lines, "\n")
+
+	got, conflicts := Merge3(base, ours, theirs)
+	lines[5] = "ours"
+	if len(conflicts) != 0 || got != strings.Join(lines, "\n") {
+		t.Fatalf("conflicts: %+v", conflicts)
+	}
+}
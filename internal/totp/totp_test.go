@@ -0,0 +1,46 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, want := range cases {
+		got, err := Code(secret, Step(time.Unix(ts, 0)))
+		if err != nil || got != want {
+			t.Fatalf("t=%d: got %s want %s (%v)", ts, got, want, err)
+		}
+	}
+}
+
+func TestValidate_SkewAndURI(t *testing.T) {
+	s, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	prev, _ := Code(s, Step(now)-1)
+	if step, ok := Validate(s, prev, now, 1); !ok || step != Step(now)-1 {
+		t.Fatalf("previous step rejected")
+	}
+	old, _ := Code(s, Step(now)-2)
+	if _, ok := Validate(s, old, now, 1); ok {
+		t.Fatal("code outside skew accepted")
+	}
+	u := URI("go-notes-api", "a@example.com", s)
+	if !strings.HasPrefix(u, "otpauth://totp/go-notes-api:a@example.com?") || !strings.Contains(u, "secret="+s) {
+		t.Fatalf("unexpected uri %s", u)
+	}
+}
@@ -0,0 +1,9 @@
+-- +migrate Up
+CREATE INDEX IF NOT EXISTS ix_notes_user_created ON notes(user_id, deleted_at, created_at);
+CREATE INDEX IF NOT EXISTS ix_notes_user_updated ON notes(user_id, deleted_at, updated_at);
+CREATE INDEX IF NOT EXISTS ix_notes_user_title ON notes(user_id, deleted_at, title(64));
+
+-- +migrate Down
+DROP INDEX IF EXISTS ix_notes_user_created ON notes;
+DROP INDEX IF EXISTS ix_notes_user_updated ON notes;
+DROP INDEX IF EXISTS ix_notes_user_title ON notes;
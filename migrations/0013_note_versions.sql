@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS note_versions(
+    user_id    BIGINT      NOT NULL PRIMARY KEY,
+    version    BIGINT      NOT NULL DEFAULT 0,
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO note_versions(user_id, version, updated_at)
+SELECT user_id, COUNT(*), MAX(updated_at) FROM notes GROUP BY user_id;
+
+-- +migrate Down
+DROP TABLE IF EXISTS note_versions;
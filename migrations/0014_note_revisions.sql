@@ -0,0 +1,18 @@
+-- +migrate Up
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS note_revisions(
+    note_id    BIGINT   NOT NULL,
+    version    INT      NOT NULL,
+    title      TEXT     NOT NULL,
+    body       TEXT     NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(note_id, version)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO note_revisions(note_id, version, title, body, created_at)
+SELECT id, version, title, body, updated_at FROM notes WHERE deleted_at IS NULL;
+
+-- +migrate Down
+DROP TABLE IF EXISTS note_revisions;
+ALTER TABLE notes DROP COLUMN IF EXISTS version;
@@ -0,0 +1,22 @@
+-- +migrate Up
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS id BIGINT NOT NULL AUTO_INCREMENT UNIQUE FIRST,
+    ADD COLUMN IF NOT EXISTS user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS device_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS last_used_at DATETIME NULL,
+    ADD COLUMN IF NOT EXISTS access_jti VARCHAR(64) NULL,
+    ADD COLUMN IF NOT EXISTS access_exp DATETIME NULL;
+CREATE INDEX IF NOT EXISTS ix_refresh_user_active ON refresh_tokens(user_id, used_at, expires_at);
+-- +migrate Down
+DROP INDEX ix_refresh_user_active ON refresh_tokens;
+ALTER TABLE refresh_tokens
+    DROP COLUMN access_exp,
+    DROP COLUMN access_jti,
+    DROP COLUMN last_used_at,
+    DROP COLUMN created_at,
+    DROP COLUMN device_name,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent,
+    DROP COLUMN id;
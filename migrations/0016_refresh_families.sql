@@ -0,0 +1,16 @@
+-- +migrate Up
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS family_id CHAR(32) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS used_ip VARCHAR(64) NULL,
+    ADD COLUMN IF NOT EXISTS used_ua VARCHAR(255) NULL;
+UPDATE refresh_tokens SET family_id=MD5(CONCAT(id, RAND())) WHERE family_id='';
+CREATE INDEX IF NOT EXISTS ix_refresh_family ON refresh_tokens(family_id);
+ALTER TABLE audit_logs
+    ADD COLUMN IF NOT EXISTS action VARCHAR(64) NULL,
+    ADD COLUMN IF NOT EXISTS meta TEXT NULL;
+CREATE INDEX IF NOT EXISTS ix_audit_action ON audit_logs(action, created_at);
+-- +migrate Down
+DROP INDEX ix_audit_action ON audit_logs;
+ALTER TABLE audit_logs DROP COLUMN meta, DROP COLUMN action;
+DROP INDEX ix_refresh_family ON refresh_tokens;
+ALTER TABLE refresh_tokens DROP COLUMN used_ua, DROP COLUMN used_ip, DROP COLUMN family_id;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS hashed TINYINT(1) NOT NULL DEFAULT 0;
+-- +migrate Down
+DELETE FROM refresh_tokens WHERE hashed=1;
+ALTER TABLE refresh_tokens DROP COLUMN hashed;
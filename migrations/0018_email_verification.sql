@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at DATETIME NULL;
+UPDATE users SET email_verified_at=NOW() WHERE email_verified_at IS NULL;
+-- +migrate Down
+ALTER TABLE users DROP COLUMN email_verified_at;
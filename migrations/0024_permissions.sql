@@ -0,0 +1,38 @@
+-- +migrate Up
+ALTER TABLE roles
+    ADD COLUMN IF NOT EXISTS description VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS builtin TINYINT(1) NOT NULL DEFAULT 0;
+UPDATE roles SET builtin=1 WHERE name IN ('admin','user');
+
+CREATE TABLE IF NOT EXISTS permissions(
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS role_permissions(
+    role_id BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL,
+    PRIMARY KEY(role_id, permission_id),
+    CONSTRAINT fk_role_permissions_role FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    CONSTRAINT fk_role_permissions_perm FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO permissions(name, description) VALUES
+    ('notes:read', 'Read own notes'),
+    ('notes:write', 'Create, update and delete own notes'),
+    ('users:read', 'List users and their roles'),
+    ('roles:read', 'List roles and permissions'),
+    ('roles:write', 'Manage roles and role assignments'),
+    ('audit:read', 'Read the audit log'),
+    ('tokens:revoke', 'Revoke access tokens by jti'),
+    ('keys:rotate', 'Rotate JWT signing keys');
+
+INSERT IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p WHERE r.name='admin';
+INSERT IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p WHERE r.name='user' AND p.name IN ('notes:read','notes:write');
+-- +migrate Down
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+ALTER TABLE roles DROP COLUMN builtin, DROP COLUMN description;
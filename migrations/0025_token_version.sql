@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
+-- +migrate Down
+ALTER TABLE users DROP COLUMN token_version;
@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status ENUM('active','suspended','disabled') NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS suspended_until DATETIME NULL,
+    ADD COLUMN IF NOT EXISTS status_reason VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS status_changed_at DATETIME NULL;
+
+INSERT IGNORE INTO permissions(name, description) VALUES
+    ('users:write', 'Manage user accounts');
+INSERT IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p WHERE r.name='admin' AND p.name='users:write';
+-- +migrate Down
+DELETE FROM permissions WHERE name='users:write';
+ALTER TABLE users DROP COLUMN status_changed_at, DROP COLUMN status_reason, DROP COLUMN suspended_until, DROP COLUMN status;
@@ -0,0 +1,11 @@
+-- +migrate Up
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS last_login_at DATETIME NULL;
+
+ALTER TABLE audit_logs
+    ADD COLUMN IF NOT EXISTS actor_id BIGINT NULL;
+CREATE INDEX IF NOT EXISTS ix_audit_actor_time ON audit_logs (actor_id, created_at);
+-- +migrate Down
+DROP INDEX ix_audit_actor_time ON audit_logs;
+ALTER TABLE audit_logs DROP COLUMN actor_id;
+ALTER TABLE users DROP COLUMN last_login_at;
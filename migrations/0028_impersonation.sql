@@ -0,0 +1,7 @@
+-- +migrate Up
+INSERT IGNORE INTO permissions(name, description) VALUES
+    ('users:impersonate', 'Act as another user with a short-lived token');
+INSERT IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r JOIN permissions p WHERE r.name='admin' AND p.name='users:impersonate';
+-- +migrate Down
+DELETE FROM permissions WHERE name='users:impersonate';
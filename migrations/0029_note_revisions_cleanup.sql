@@ -0,0 +1,5 @@
+-- +migrate Up
+DELETE nr FROM note_revisions nr JOIN notes n ON n.id=nr.note_id WHERE n.deleted_at IS NOT NULL;
+
+-- +migrate Down
+-- revisions of deleted notes are not restored
@@ -0,0 +1,10 @@
+-- +migrate Up
+CREATE TEMPORARY TABLE refresh_family_map AS
+SELECT f.family_id AS old_id, MD5(CONCAT(UUID(), RAND())) AS new_id
+FROM (SELECT DISTINCT family_id FROM refresh_tokens WHERE hashed=0 AND family_id=LEFT(token,32)) f;
+UPDATE refresh_tokens rt JOIN refresh_family_map m ON m.old_id=rt.family_id SET rt.family_id=m.new_id;
+DROP TEMPORARY TABLE refresh_family_map;
+UPDATE refresh_tokens SET token=SHA2(token,256), hashed=2 WHERE hashed=0;
+
+-- +migrate Down
+-- hashed tokens cannot be restored to plaintext
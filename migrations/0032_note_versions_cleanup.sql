@@ -0,0 +1,5 @@
+-- +migrate Up
+DELETE nv FROM note_versions nv LEFT JOIN users u ON u.id=nv.user_id WHERE u.id IS NULL;
+
+-- +migrate Down
+-- counters of deleted users are not restored
@@ -1,15 +1,16 @@
 package errors
 
 import (
-	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	apperr "github.com/Veysel440/go-notes-api/internal/errors"
 )
 
 func TestWrite_JSONShape(t *testing.T) {
 	rr := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/x", nil)
-	Write(rr, req, E(400, "bad_request", "bad", nil, nil))
+	apperr.Write(rr, req, apperr.E(400, "bad_request", "bad", nil, nil))
 	if rr.Code != 400 {
 		t.Fatalf("code %d", rr.Code)
 	}
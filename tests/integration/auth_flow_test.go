@@ -19,13 +19,13 @@ func baseURL() string {
 
 func TestAuthFlow(t *testing.T) {
 	b := baseURL()
-	body := bytes.NewBufferString(`{"email":"a@b.c","password":"Password1!"}`)
+	body := bytes.NewBufferString(`{"email":"a@b.c","password":"Mavi-Kaplumbaga-47"}`)
 	resp, err := http.Post(b+"/auth/register", "application/json", body)
 	if err != nil || (resp.StatusCode != 200 && resp.StatusCode != 409) {
 		t.Fatalf("register err %v code %d", err, resp.StatusCode)
 	}
 
-	body = bytes.NewBufferString(`{"email":"a@b.c","password":"Password1!"}`)
+	body = bytes.NewBufferString(`{"email":"a@b.c","password":"Mavi-Kaplumbaga-47"}`)
 	resp, err = http.Post(b+"/auth/login", "application/json", body)
 	if err != nil || resp.StatusCode != 200 {
 		t.Fatalf("login err %v code %d", err, resp.StatusCode)